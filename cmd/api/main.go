@@ -1,54 +1,302 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/netip"
 	"os"
+	"pilem/internal/database"
+	"pilem/internal/mailer"
 	"pilem/internal/server"
+	"pilem/migrations"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// version is the version of the binary, set at build time with
+// -ldflags "-X main.version=...".
+var version = "dev"
+
 type config struct {
+	port            int
+	env             string
+	shutdownTimeout time.Duration
+	store           string
+	log             struct {
+		level  slog.Level
+		format string
+	}
 	db struct {
-		dsn string
+		driver       string
+		dsn          string
+		maxOpenConns int
+		maxIdleConns int
+		maxIdleTime  time.Duration
+		maxLifetime  time.Duration
+		queryTimeout time.Duration
+		// migrateOnStart applies the pending migrations before serving.
+		migrateOnStart bool
+	}
+	limiter struct {
+		enabled        bool
+		backend        string
+		rps            float64
+		burst          int
+		failOpen       bool
+		trustedProxies string
+	}
+	cors struct {
+		trustedOrigins []string
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
 	}
 }
 
 func main() {
 	var cfg config
 
+	// The .env file is optional, the environment may be set by other means.
 	err := godotenv.Load()
-	if err != nil {
-		panic(err)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
+	port, _ := strconv.Atoi(os.Getenv("PORT"))
+
+	// server config
+	flag.IntVar(&cfg.port, "port", port, "API server port")
+	flag.StringVar(&cfg.env, "env", os.Getenv("APP_ENV"), "Environment (development|staging|production)")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second, "Time allowed for in-flight requests to complete on shutdown")
+	flag.StringVar(&cfg.store, "store", "postgres", "Store (postgres|memory), memory keeps everything in memory without a database, for tests and demos")
+
+	// log config
+	flag.TextVar(&cfg.log.level, "log-level", slog.LevelInfo, "Log level (debug|info|warn|error)")
+	flag.StringVar(&cfg.log.format, "log-format", "text", "Log format (text|json)")
+
 	// database config
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("DB_DSN"), "PostgreSQL DSN")
+	flag.StringVar(&cfg.db.driver, "db-driver", "postgres", "Database driver (postgres|sqlite), sqlite doesn't support -limiter-backend=postgres")
+	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("DB_DSN"), "Database DSN")
+	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "Database max open connections")
+	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "Database max idle connections")
+	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "Database max connection idle time")
+	flag.DurationVar(&cfg.db.maxLifetime, "db-max-lifetime", time.Hour, "Database max connection lifetime")
+	flag.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", 3*time.Second, "Database query timeout")
+	flag.BoolVar(&cfg.db.migrateOnStart, "migrate-on-start", false, "Apply pending migrations before serving")
+
+	// rate limiter config
+	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable per-client rate limiter")
+	flag.StringVar(&cfg.limiter.backend, "limiter-backend", "memory", "Rate limiter backend (memory|postgres)")
+	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	flag.BoolVar(&cfg.limiter.failOpen, "limiter-fail-open", true, "Allow requests when the rate limiter fails, instead of rejecting them")
+	flag.StringVar(&cfg.limiter.trustedProxies, "limiter-trusted-proxies", "", "Trusted proxy IPs or CIDRs (space separated)")
+
+	// cors config
+	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
+		cfg.cors.trustedOrigins = strings.Fields(val)
+		return nil
+	})
+
+	// smtp config, an empty host writes emails to stdout instead of sending them
+	flag.StringVar(&cfg.smtp.host, "smtp-host", os.Getenv("SMTP_HOST"), "SMTP host")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Pilem <no-reply@pilem.local>", "SMTP sender")
 
 	flag.Parse()
 
-	// OpenDB
-	db, err := openDB(cfg)
+	logger, err := newLogger(cfg)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if cfg.store != "memory" && cfg.store != "postgres" {
+		logger.Error("invalid -store, must be postgres or memory", "store", cfg.store)
+		os.Exit(2)
 	}
-	defer db.Close()
 
-	server := server.NewServer(db)
+	if cfg.db.driver != "postgres" && cfg.db.driver != "sqlite" {
+		logger.Error("invalid -db-driver, must be postgres or sqlite", "driver", cfg.db.driver)
+		os.Exit(2)
+	}
+
+	if cfg.limiter.backend != "memory" && cfg.limiter.backend != "postgres" {
+		logger.Error("invalid -limiter-backend, must be memory or postgres", "backend", cfg.limiter.backend)
+		os.Exit(2)
+	}
+
+	// NaN and +Inf would make the window of the postgres limiter meaningless.
+	if !(cfg.limiter.rps > 0) || math.IsInf(cfg.limiter.rps, 1) {
+		logger.Error("invalid -limiter-rps, must be greater than 0", "rps", cfg.limiter.rps)
+		os.Exit(2)
+	}
+
+	if cfg.limiter.burst <= 0 {
+		logger.Error("invalid -limiter-burst, must be greater than 0", "burst", cfg.limiter.burst)
+		os.Exit(2)
+	}
 
-	err = server.ListenAndServe()
+	if cfg.limiter.backend == "postgres" && (cfg.db.driver != "postgres" || cfg.store == "memory") {
+		logger.Error("-limiter-backend=postgres needs -store=postgres and -db-driver=postgres")
+		os.Exit(2)
+	}
+
+	if cfg.store == "memory" && (flag.NArg() > 0 || cfg.db.migrateOnStart) {
+		logger.Error("-store=memory has no database to run commands or -migrate-on-start on")
+		os.Exit(2)
+	}
+
+	trustedProxies, err := parsePrefixes(cfg.limiter.trustedProxies)
 	if err != nil {
-		panic(fmt.Sprintf("cannot start server: %s", err))
+		logger.Error("invalid -limiter-trusted-proxies", "error", err)
+		os.Exit(2)
+	}
+
+	// The memory store runs without a database, db stays nil.
+	var db *sql.DB
+	if cfg.store != "memory" {
+		db, err = openDB(cfg)
+		if err != nil {
+			logger.Error("can't open database", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// The arguments left after the flags are a command, like "migrate up".
+	if flag.NArg() > 0 {
+		err := runCommand(context.Background(), os.Stdout, logger, db, cfg.db.driver, flag.Args())
+		db.Close()
+
+		switch {
+		case errors.Is(err, errUsage):
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		case err != nil:
+			logger.Error("command failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cfg.db.migrateOnStart {
+		_, err := database.NewMigrator(db, cfg.db.driver, migrations.For(cfg.db.driver), logger).Up(context.Background())
+		if err != nil {
+			logger.Error("can't apply migrations", "error", err)
+			db.Close()
+			os.Exit(1)
+		}
+	}
+
+	var m mailer.Mailer = mailer.NewLogMailer(os.Stdout)
+	if cfg.smtp.host != "" {
+		m = mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
+	}
+
+	srv := server.NewServer(server.Config{
+		Port:            cfg.port,
+		Env:             cfg.env,
+		Version:         version,
+		ShutdownTimeout: cfg.shutdownTimeout,
+		QueryTimeout:    cfg.db.queryTimeout,
+		DBDriver:        cfg.db.driver,
+		Store:           cfg.store,
+		Limiter: server.LimiterConfig{
+			Enabled:        cfg.limiter.enabled,
+			Backend:        cfg.limiter.backend,
+			RPS:            cfg.limiter.rps,
+			Burst:          cfg.limiter.burst,
+			FailOpen:       cfg.limiter.failOpen,
+			TrustedProxies: trustedProxies,
+		},
+		CORSTrustedOrigins: cfg.cors.trustedOrigins,
+	}, logger, db, m)
+
+	// Serve only returns once the server stopped and the background tasks
+	// are done, so the pool can be closed safely afterwards.
+	err = srv.Serve()
+	if err != nil {
+		logger.Error("server error", "error", err)
+	}
+
+	if db != nil {
+		if closeErr := db.Close(); closeErr != nil {
+			logger.Error("can't close database", "error", closeErr)
+		}
 	}
-}
 
-func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("pgx", cfg.db.dsn)
 	if err != nil {
-		return nil, err
+		os.Exit(1)
 	}
+}
+
+// newLogger returns the structured logger described by the log config,
+// writing to stdout.
+func newLogger(cfg config) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: cfg.log.level}
+
+	switch cfg.log.format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stdout, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stdout, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, must be text or json", cfg.log.format)
+	}
+}
+
+// parsePrefixes parses a space-separated list of IPs and CIDRs. A single IP
+// becomes a prefix matching only itself.
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, field := range strings.Fields(s) {
+		if strings.Contains(field, "/") {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+// openDB opens the connection pool, giving the database 5 seconds to answer.
+func openDB(cfg config) (*sql.DB, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	return db, nil
+	return database.Open(ctx, database.Config{
+		Driver:       cfg.db.driver,
+		DSN:          cfg.db.dsn,
+		MaxOpenConns: cfg.db.maxOpenConns,
+		MaxIdleConns: cfg.db.maxIdleConns,
+		MaxIdleTime:  cfg.db.maxIdleTime,
+		MaxLifetime:  cfg.db.maxLifetime,
+	})
 }
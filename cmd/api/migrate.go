@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"pilem/internal/database"
+	"pilem/migrations"
+	"strconv"
+)
+
+// errUsage is returned for a command called with invalid arguments.
+var errUsage = errors.New("usage: pilem [flags] migrate up|down N|status|force V")
+
+// runCommand runs the command given after the flags, like "migrate up".
+func runCommand(ctx context.Context, w io.Writer, logger *slog.Logger, db *sql.DB, driver string, args []string) error {
+	if args[0] != "migrate" || len(args) < 2 {
+		return errUsage
+	}
+
+	m := database.NewMigrator(db, driver, migrations.For(driver), logger)
+
+	switch args[1] {
+	case "up":
+		if len(args) != 2 {
+			return errUsage
+		}
+
+		applied, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%d migrations applied\n", applied)
+
+	case "down":
+		if len(args) != 3 {
+			return errUsage
+		}
+
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 1 {
+			return errUsage
+		}
+
+		rolledBack, err := m.Down(ctx, n)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%d migrations rolled back\n", rolledBack)
+
+	case "status":
+		if len(args) != 2 {
+			return errUsage
+		}
+
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		writeMigrationStatus(w, status)
+
+	case "force":
+		if len(args) != 3 {
+			return errUsage
+		}
+
+		version, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil || version < 0 {
+			return errUsage
+		}
+
+		err = m.Force(ctx, version)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "version forced to %d\n", version)
+
+	default:
+		return errUsage
+	}
+
+	return nil
+}
+
+func writeMigrationStatus(w io.Writer, status database.MigrationStatus) {
+	fmt.Fprintf(w, "version: %d\n", status.Version)
+	fmt.Fprintf(w, "latest:  %d\n", status.Latest)
+	fmt.Fprintf(w, "pending: %d\n", status.Pending)
+
+	if status.Dirty {
+		fmt.Fprintf(w, "version %d is dirty, fix it then run: pilem migrate force %d\n", status.Version, status.Version)
+	}
+
+	for _, version := range status.EmptyDown {
+		fmt.Fprintf(w, "warning: migration %d has an empty down migration and can't be rolled back\n", version)
+	}
+}
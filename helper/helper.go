@@ -1,12 +1,16 @@
 package helper
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"pilem/internal/validator"
 	"strconv"
 	"strings"
 	"testing"
@@ -16,6 +20,28 @@ import (
 
 type Envelope map[string]any
 
+type contextKey string
+
+const loggerContextKey = contextKey("logger")
+
+// ContextSetLogger returns a copy of r carrying logger in its context.
+func ContextSetLogger(r *http.Request, logger *slog.Logger) *http.Request {
+	ctx := context.WithValue(r.Context(), loggerContextKey, logger)
+	return r.WithContext(ctx)
+}
+
+// ContextGetLogger returns the request-scoped logger of r, or slog.Default()
+// when the request didn't go through a middleware setting one, like in
+// handler unit tests.
+func ContextGetLogger(r *http.Request) *slog.Logger {
+	logger, ok := r.Context().Value(loggerContextKey).(*slog.Logger)
+	if !ok {
+		return slog.Default()
+	}
+
+	return logger
+}
+
 // WriteJSON write json with http.ResponseWriter
 func WriteJSON(w http.ResponseWriter, status int, data Envelope, headers http.Header) error {
 
@@ -129,15 +155,76 @@ func ReadIDParam(r *http.Request) (int64, error) {
 
 }
 
+// ReadString returns the string value of key from the query string, or
+// defaultValue when the key is missing or empty.
+func ReadString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	return s
+}
+
+// ReadCSV splits the comma-separated value of key from the query string, or
+// returns defaultValue when the key is missing or empty.
+func ReadCSV(qs url.Values, key string, defaultValue []string) []string {
+	csv := qs.Get(key)
+	if csv == "" {
+		return defaultValue
+	}
+
+	return strings.Split(csv, ",")
+}
+
+// ReadInt parses the value of key from the query string as an integer, or
+// returns defaultValue when the key is missing or empty. A value that isn't an
+// integer is recorded in v and defaultValue is returned.
+func ReadInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+
+	return i
+}
+
+// ReadBool parses the value of key from the query string as a boolean, or
+// returns defaultValue when the key is missing or empty. A value that isn't a
+// boolean is recorded in v and defaultValue is returned.
+func ReadBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
 func ErrorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
 	env := Envelope{"error": message}
 	err := WriteJSON(w, status, env, nil)
 	if err != nil {
+		ContextGetLogger(r).Error(err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
 func ServerErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	ContextGetLogger(r).Error("server error", "error", err)
+
 	message := "the server encountered a problem and could not process your request"
 	ErrorResponse(w, r, http.StatusInternalServerError, message)
 }
@@ -147,15 +234,83 @@ func NotFoundResponse(w http.ResponseWriter, r *http.Request, err error) {
 	ErrorResponse(w, r, http.StatusNotFound, message)
 }
 
+func MethodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
+	ErrorResponse(w, r, http.StatusMethodNotAllowed, message)
+}
+
+func FailedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
+	ErrorResponse(w, r, http.StatusUnprocessableEntity, errors)
+}
+
 func BadRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
 	ErrorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
 }
 
+// UnsupportedMediaTypeResponse rejects a body whose Content-Type isn't one of
+// mediaTypes.
+func UnsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, mediaTypes ...string) {
+	message := fmt.Sprintf("the request body must be %s", strings.Join(mediaTypes, " or "))
+	ErrorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
 func EditConflictResponse(w http.ResponseWriter, r *http.Request, err error) {
 	message := "unable to update the record due to an edit conflict, please try again"
 	ErrorResponse(w, r, http.StatusUnprocessableEntity, message)
 }
 
+func ConflictResponse(w http.ResponseWriter, r *http.Request, message string) {
+	ErrorResponse(w, r, http.StatusConflict, message)
+}
+
+func ServiceUnavailableResponse(w http.ResponseWriter, r *http.Request, err error) {
+	message := "the server is temporarily unable to process your request, please try again later"
+	ErrorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+// StatusClientClosedRequest is the non-standard status, borrowed from nginx,
+// of a request whose client went away before the response was written.
+const StatusClientClosedRequest = 499
+
+// ClientClosedRequestResponse logs that the client of r went away and sets
+// the status to StatusClientClosedRequest, without a body nobody would read.
+func ClientClosedRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
+	ContextGetLogger(r).Info("client closed request", "status", StatusClientClosedRequest, "error", err)
+	w.WriteHeader(StatusClientClosedRequest)
+}
+
+func InvalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	ErrorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func InvalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	ErrorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func AuthenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	ErrorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func NotPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	ErrorResponse(w, r, http.StatusForbidden, message)
+}
+
+func InactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	ErrorResponse(w, r, http.StatusForbidden, message)
+}
+
+func RateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+	message := "rate limit exceeded"
+	ErrorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 // NewSQLMock helper for stub sql
 func NewSQLMock(t *testing.T, fn func(mock sqlmock.Sqlmock)) (*sql.DB, sqlmock.Sqlmock) {
 	t.Helper()
@@ -0,0 +1,82 @@
+package data
+
+import (
+	"math"
+	"pilem/internal/validator"
+	"slices"
+	"strings"
+)
+
+// Filters holds the pagination and sorting options of a list request.
+type Filters struct {
+	Page     int
+	PageSize int
+	Sort     string
+	// SortSafelist contains the values Sort is allowed to take. A leading "-"
+	// means descending order.
+	SortSafelist []string
+}
+
+// ValidateFilters checks page, page size and sort against their permitted
+// values.
+func ValidateFilters(v *validator.Validator, f Filters) {
+	v.Check(f.Page > 0, "page", "must be greater than zero")
+	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
+	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+
+	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+}
+
+// SortColumn returns the column name to order by, without the "-" prefix.
+// It panics when Sort isn't in the safelist, as a last line of defence
+// against SQL injection since the value ends up in the query text.
+func (f Filters) SortColumn() string {
+	if slices.Contains(f.SortSafelist, f.Sort) {
+		return strings.TrimPrefix(f.Sort, "-")
+	}
+
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+// SortDirection returns "ASC" or "DESC" depending on the "-" prefix of Sort.
+func (f Filters) SortDirection() string {
+	if strings.HasPrefix(f.Sort, "-") {
+		return "DESC"
+	}
+
+	return "ASC"
+}
+
+func (f Filters) Limit() int {
+	return f.PageSize
+}
+
+func (f Filters) Offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// Metadata describes the pagination state of a list response.
+type Metadata struct {
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
+	TotalRecords int `json:"total_records,omitempty"`
+}
+
+// CalculateMetadata builds the Metadata for a page. It returns an empty
+// Metadata when there are no records, so the response carries no page info.
+func CalculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
+	}
+}
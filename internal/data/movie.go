@@ -3,6 +3,7 @@ package data
 import (
 	"errors"
 	"fmt"
+	"pilem/internal/validator"
 	"strconv"
 	"strings"
 	"time"
@@ -19,6 +20,33 @@ type Movie struct {
 	Version int32 `json:"version,omitempty"`
 }
 
+// MovieSearchResult is a movie matched by a full-text search together with
+// how relevant it is to the search query.
+type MovieSearchResult struct {
+	*Movie
+	Rank float32 `json:"rank"`
+}
+
+// ValidateMovie checks movie against the same rules the movies table enforces
+// with its CHECK constraints, so bad input is reported per field instead of
+// failing on insert.
+func ValidateMovie(v *validator.Validator, movie *Movie) {
+	v.Check(movie.Title != "", "title", "must be provided")
+	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
+
+	v.Check(movie.Year != 0, "year", "must be provided")
+	v.Check(movie.Year >= 1888, "year", "must be 1888 or later")
+	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
+
+	v.Check(movie.Runtime != 0, "runtime", "must be provided")
+	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
+
+	v.Check(movie.Genres != nil, "genres", "must be provided")
+	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
+	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
+	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+}
+
 // Define an error that our UnmarshalJSON() method can return if we're unable to parse
 // or convert the JSON string successfully.
 var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
@@ -0,0 +1,19 @@
+package data
+
+import "slices"
+
+// Permission codes granted to users.
+const (
+	PermissionMoviesRead  = "movies:read"
+	PermissionMoviesWrite = "movies:write"
+	// PermissionMetricsRead grants access to /debug/vars and /metrics.
+	PermissionMetricsRead = "metrics:read"
+)
+
+// Permissions holds the permission codes of a user, like "movies:read".
+type Permissions []string
+
+// Include reports whether code is one of the permissions.
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
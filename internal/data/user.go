@@ -0,0 +1,96 @@
+package data
+
+import (
+	"errors"
+	"pilem/internal/validator"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AnonymousUser represents a request without an authentication token.
+var AnonymousUser = &User{}
+
+type User struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Password  Password  `json:"-"`
+	Activated bool      `json:"activated"`
+	// The version number start at 1 and will incremented each time the user is updated
+	Version int32 `json:"-"`
+}
+
+// IsAnonymous reports whether u is the AnonymousUser.
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
+// Password holds the plaintext password of a user, when we have it, and its
+// bcrypt hash. It always marshals to null so neither value can end up in a
+// response by accident.
+type Password struct {
+	// Plaintext is nil unless the password was set from user input.
+	Plaintext *string
+	Hash      []byte
+}
+
+// Set hashes plaintextPassword with bcrypt and stores both values.
+func (p *Password) Set(plaintextPassword string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	if err != nil {
+		return err
+	}
+
+	p.Plaintext = &plaintextPassword
+	p.Hash = hash
+
+	return nil
+}
+
+// Matches reports whether plaintextPassword matches the stored hash.
+func (p *Password) Matches(plaintextPassword string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(p.Hash, []byte(plaintextPassword))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (p Password) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
+
+func ValidateEmail(v *validator.Validator, email string) {
+	v.Check(email != "", "email", "must be provided")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+}
+
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
+	// bcrypt silently truncates anything after 72 bytes.
+	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+}
+
+func ValidateUser(v *validator.Validator, user *User) {
+	v.Check(user.Name != "", "name", "must be provided")
+	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	ValidateEmail(v, user.Email)
+
+	// A user without a plaintext password was read from the database, so a
+	// missing hash is a bug in our code rather than bad input.
+	if user.Password.Plaintext != nil {
+		ValidatePasswordPlaintext(v, *user.Password.Plaintext)
+	} else if user.Password.Hash == nil {
+		panic("missing password hash for user")
+	}
+}
@@ -0,0 +1,149 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"pilem/internal/data"
+	"pilem/internal/database"
+	"slices"
+	"testing"
+	"time"
+)
+
+// runAccountTests checks the users, tokens and permissions of the models
+// returned by newModels, which are empty.
+func runAccountTests(t *testing.T, newModels func(t *testing.T) database.Models) {
+	t.Run("Users", func(t *testing.T) { testUserStore(t, newModels(t)) })
+	t.Run("Tokens", func(t *testing.T) { testTokenStore(t, newModels(t)) })
+	t.Run("Permissions", func(t *testing.T) { testPermissionStore(t, newModels(t)) })
+}
+
+// insertUser inserts an activated user with email into m.
+func insertUser(t *testing.T, m database.Models, email string) *data.User {
+	t.Helper()
+
+	user := &data.User{
+		Name:      "alice",
+		Email:     email,
+		Password:  data.Password{Hash: []byte("hash")},
+		Activated: true,
+	}
+
+	err := m.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func testUserStore(t *testing.T, m database.Models) {
+	ctx := context.Background()
+
+	user := insertUser(t, m, "alice@example.com")
+	if user.ID == 0 || user.Version != 1 || time.Since(user.CreatedAt) > time.Minute {
+		t.Fatalf("want id, version and created_at set got %+v", user)
+	}
+
+	err := m.Users.Insert(ctx, &data.User{Email: "ALICE@example.com", Password: data.Password{Hash: []byte("hash")}})
+	if !errors.Is(err, database.ErrDuplicateEmail) {
+		t.Errorf("want ErrDuplicateEmail got %v", err)
+	}
+
+	got, err := m.Users.GetByEmail(ctx, "Alice@Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.ID != user.ID || !got.Activated || string(got.Password.Hash) != "hash" || !got.CreatedAt.Equal(user.CreatedAt) {
+		t.Errorf("want %+v got %+v", user, got)
+	}
+
+	got.Name = "alice smith"
+	if err := m.Users.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Version != 2 {
+		t.Errorf("want version 2 got %d", got.Version)
+	}
+
+	err = m.Users.Update(ctx, user)
+	if !errors.Is(err, database.ErrEditConflict) {
+		t.Errorf("want ErrEditConflict for a stale version got %v", err)
+	}
+
+	_, err = m.Users.GetByEmail(ctx, "bob@example.com")
+	if !errors.Is(err, database.ErrRecordNotFound) {
+		t.Errorf("want ErrRecordNotFound got %v", err)
+	}
+}
+
+func testTokenStore(t *testing.T, m database.Models) {
+	ctx := context.Background()
+
+	user := insertUser(t, m, "alice@example.com")
+
+	token, err := m.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := m.Tokens.New(ctx, user.ID, -time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.Users.GetForToken(ctx, data.ScopeAuthentication, token.Plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.ID != user.ID {
+		t.Errorf("want user %d got %d", user.ID, got.ID)
+	}
+
+	for _, tt := range []struct{ name, scope, plaintext string }{
+		{"expired token", data.ScopeAuthentication, expired.Plaintext},
+		{"other scope", data.ScopeActivation, token.Plaintext},
+	} {
+		_, err := m.Users.GetForToken(ctx, tt.scope, tt.plaintext)
+		if !errors.Is(err, database.ErrRecordNotFound) {
+			t.Errorf("%s: want ErrRecordNotFound got %v", tt.name, err)
+		}
+	}
+
+	err = m.Tokens.DeleteAllForUser(ctx, data.ScopeAuthentication, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Users.GetForToken(ctx, data.ScopeAuthentication, token.Plaintext)
+	if !errors.Is(err, database.ErrRecordNotFound) {
+		t.Errorf("want ErrRecordNotFound after delete got %v", err)
+	}
+}
+
+func testPermissionStore(t *testing.T, m database.Models) {
+	ctx := context.Background()
+
+	user := insertUser(t, m, "alice@example.com")
+
+	// Granting a permission twice or an unknown one isn't an error.
+	for range 2 {
+		err := m.Permissions.AddForUser(ctx, user.ID, data.PermissionMoviesRead, data.PermissionMetricsRead, "movies:unknown")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := m.Permissions.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(got)
+	if want := (data.Permissions{data.PermissionMetricsRead, data.PermissionMoviesRead}); !slices.Equal(got, want) {
+		t.Errorf("want %v got %v", want, got)
+	}
+}
@@ -1,6 +1,8 @@
 package database_test
 
 import (
+	"context"
+	"errors"
 	"pilem/helper"
 	"pilem/internal/data"
 	"pilem/internal/database"
@@ -47,7 +49,7 @@ func TestMovieInsert_GetIDCreatedAtVersionWhenInsertingNewMovie(t *testing.T) {
 		Genres:  []string{"Adventure", "Action", "Fantasy"},
 	}
 
-	err := m.Insert(&movie)
+	err := m.Insert(context.Background(), &movie)
 	if err != nil {
 		t.Fatalf("can't insert movie. Err: %s", err)
 	}
@@ -101,8 +103,8 @@ func TestMovieGet_GetMovie(t *testing.T) {
 		mock.ExpectQuery(query).WithArgs(want.ID).WillReturnRows(rows)
 	})
 
-	m := database.NewModels(db)
-	got, err := m.Movies.Get(want.ID)
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+	got, err := m.Movies.Get(context.Background(), want.ID)
 	if err != nil {
 		t.Fatalf("can't get movie. Err:%v", err)
 	}
@@ -149,9 +151,9 @@ func TestMovieDelete_DeleteMovie(t *testing.T) {
 		mock.ExpectExec(query).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, id))
 	})
 
-	m := database.NewModels(db)
+	m := database.NewModels(db, database.DefaultQueryTimeout)
 
-	err := m.Movies.Delete(id)
+	err := m.Movies.Delete(context.Background(), id)
 	if err != nil {
 		t.Fatalf("Can't delete movie id: %d, Err: %v", id, err)
 	}
@@ -193,9 +195,9 @@ func TestMovieUpdate_UpdateMovie(t *testing.T) {
 		).WillReturnRows(rows)
 	})
 
-	m := database.NewModels(db)
+	m := database.NewModels(db, database.DefaultQueryTimeout)
 
-	err := m.Movies.Update(&want)
+	err := m.Movies.Update(context.Background(), &want)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -204,3 +206,164 @@ func TestMovieUpdate_UpdateMovie(t *testing.T) {
 		t.Error("unfulfilled query")
 	}
 }
+
+func TestMovieList_ReturnMoviesAndMetadata(t *testing.T) {
+	t.Parallel()
+
+	want := []data.Movie{
+		{ID: 1, CreatedAt: time.Now(), Title: "overlord", Year: 2024, Runtime: 135, Genres: []string{"Action", "Fantasy"}, Version: 1},
+		{ID: 2, CreatedAt: time.Now(), Title: "overlord II", Year: 2025, Runtime: 120, Genres: []string{"Action"}, Version: 3},
+	}
+
+	filters := data.Filters{
+		Page:         1,
+		PageSize:     2,
+		Sort:         "-year",
+		SortSafelist: []string{"year", "-year"},
+	}
+
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		query := `SELECT count\(\*\) OVER\(\), (.+) FROM movies (.+) ORDER BY year DESC, id ASC`
+		rows := sqlmock.NewRows([]string{"count", "id", "created_at", "title", "year", "runtime", "genres", "version"})
+		for _, m := range want {
+			rows.AddRow(5, m.ID, m.CreatedAt, m.Title, m.Year, m.Runtime, pq.Array(m.Genres), m.Version)
+		}
+		mock.ExpectQuery(query).
+			WithArgs(`over\%lord`, pq.Array([]string{"Action"}), filters.Limit(), filters.Offset()).
+			WillReturnRows(rows)
+	})
+
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+	movies, metadata, err := m.Movies.List(context.Background(), "over%lord", []string{"Action"}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal("query not as expected", err)
+	}
+
+	if len(movies) != len(want) {
+		t.Fatalf("want %d movies got %d", len(want), len(movies))
+	}
+
+	for i := range want {
+		if !cmp.Equal(want[i], *movies[i]) {
+			t.Error(cmp.Diff(want[i], *movies[i]))
+		}
+	}
+
+	wantMetadata := data.Metadata{CurrentPage: 1, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5}
+	if !cmp.Equal(wantMetadata, metadata) {
+		t.Error(cmp.Diff(wantMetadata, metadata))
+	}
+}
+
+func TestMovieInsert_TranslateCheckViolation(t *testing.T) {
+	t.Parallel()
+
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("INSERT INTO movies").
+			WillReturnError(&pq.Error{Code: "23514", Constraint: "movies_year_check"})
+	})
+
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+	err := m.Movies.Insert(context.Background(), &data.Movie{Title: "overlord", Year: 1700, Runtime: 135, Genres: []string{"Action"}})
+
+	if !errors.Is(err, database.ErrCheckViolation) {
+		t.Fatalf("want ErrCheckViolation got %v", err)
+	}
+
+	var queryErr *database.QueryError
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("want *database.QueryError got %T", err)
+	}
+
+	if queryErr.Constraint != "movies_year_check" {
+		t.Errorf("want constraint movies_year_check got %q", queryErr.Constraint)
+	}
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		t.Error("want the driver error to stay reachable")
+	}
+}
+
+func TestMovieUpdate_KeepUnknownErrorsAsIs(t *testing.T) {
+	t.Parallel()
+
+	want := &pq.Error{Code: "42P01"}
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("UPDATE movies").WillReturnError(want)
+	})
+
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+	err := m.Movies.Update(context.Background(), &data.Movie{ID: 1, Version: 1})
+
+	if err != want {
+		t.Errorf("want %v got %v", want, err)
+	}
+}
+
+func TestMovieSearch_ReturnMoviesWithRank(t *testing.T) {
+	t.Parallel()
+
+	want := data.MovieSearchResult{
+		Movie: &data.Movie{ID: 4, CreatedAt: time.Now(), Title: "overlord", Year: 2024, Runtime: 135, Genres: []string{"Action"}, Version: 1},
+		Rank:  0.6,
+	}
+
+	filters := data.Filters{
+		Page:         1,
+		PageSize:     20,
+		Sort:         "-relevance",
+		SortSafelist: []string{"relevance", "-relevance"},
+	}
+
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		query := `websearch_to_tsquery(.+) ORDER BY relevance DESC, id ASC`
+		rows := sqlmock.NewRows([]string{"count", "id", "created_at", "title", "year", "runtime", "genres", "version", "relevance"}).
+			AddRow(1, want.ID, want.CreatedAt, want.Title, want.Year, want.Runtime, pq.Array(want.Genres), want.Version, want.Rank)
+		mock.ExpectQuery(query).
+			WithArgs("overlord", pq.Array([]string{}), filters.Limit(), filters.Offset()).
+			WillReturnRows(rows)
+	})
+
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+	results, metadata, err := m.Movies.Search(context.Background(), "overlord", []string{}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal("query not as expected", err)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("want 1 result got %d", len(results))
+	}
+
+	if !cmp.Equal(want, *results[0]) {
+		t.Error(cmp.Diff(want, *results[0]))
+	}
+
+	if metadata.TotalRecords != 1 {
+		t.Errorf("want 1 total record got %d", metadata.TotalRecords)
+	}
+}
+
+func TestMovieGet_StopWhenContextIsCanceled(t *testing.T) {
+	t.Parallel()
+
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+
+	_, err := m.Movies.Get(ctx, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled got %v", err)
+	}
+}
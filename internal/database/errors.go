@@ -0,0 +1,154 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Errors reported by the database for the SQLSTATE codes callers can act
+// upon. Use errors.Is to test for them and errors.As with *QueryError to
+// get the violated constraint.
+var (
+	ErrCheckViolation       = errors.New("check constraint violation")
+	ErrUniqueViolation      = errors.New("unique constraint violation")
+	ErrForeignKeyViolation  = errors.New("foreign key violation")
+	ErrSerializationFailure = errors.New("serialization failure")
+	ErrQueryCanceled        = errors.New("query canceled")
+)
+
+// sqlStates maps the SQLSTATE codes we translate to their sentinel error.
+var sqlStates = map[pq.ErrorCode]error{
+	"23514": ErrCheckViolation,
+	"23505": ErrUniqueViolation,
+	"23503": ErrForeignKeyViolation,
+	"40001": ErrSerializationFailure,
+	"57014": ErrQueryCanceled,
+}
+
+// QueryError wraps a driver error whose SQLSTATE code has a sentinel error.
+type QueryError struct {
+	// Code is the SQLSTATE code returned by the server.
+	Code string
+	// Constraint is the name of the violated constraint, if any.
+	Constraint string
+	// Err is the original driver error.
+	Err error
+}
+
+func (e *QueryError) Error() string {
+	if e.Constraint != "" {
+		return fmt.Sprintf("%s on %q: %v", sqlStates[pq.ErrorCode(e.Code)], e.Constraint, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %v", sqlStates[pq.ErrorCode(e.Code)], e.Err)
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the sentinel error of the SQLSTATE code.
+func (e *QueryError) Is(target error) bool {
+	return sqlStates[pq.ErrorCode(e.Code)] == target
+}
+
+// sqliteCheckPrefix starts the message of a violated CHECK constraint in
+// SQLite, followed by the constraint name.
+const sqliteCheckPrefix = "CHECK constraint failed: "
+
+// sqliteForeignKeyMessage is the message of a violated foreign key in
+// SQLite, which doesn't name the constraint.
+const sqliteForeignKeyMessage = "FOREIGN KEY constraint failed"
+
+// sqliteUniquePrefix starts the message of a violated UNIQUE constraint in
+// SQLite, followed by the columns, like "users.email".
+const sqliteUniquePrefix = "UNIQUE constraint failed: "
+
+// translateError returns err as a *QueryError when it is a driver error with a
+// known SQLSTATE code, and err unchanged otherwise. The SQLite errors with a
+// PostgreSQL equivalent get its SQLSTATE code.
+func translateError(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return translateSQLiteError(err, sqliteErr)
+	}
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	if _, ok := sqlStates[pqErr.Code]; !ok {
+		return err
+	}
+
+	return &QueryError{
+		Code:       string(pqErr.Code),
+		Constraint: pqErr.Constraint,
+		Err:        err,
+	}
+}
+
+// translateSQLiteError translates the CHECK constraint violations, reported
+// by a constraint or by a trigger raising the same message, and the UNIQUE
+// and foreign key constraint violations.
+func translateSQLiteError(err error, sqliteErr *sqlite.Error) error {
+	if sqliteErr.Code()&0xff != sqlite3.SQLITE_CONSTRAINT {
+		return err
+	}
+
+	// The messages end with the result code, like "(275)".
+	if _, constraint, ok := strings.Cut(sqliteErr.Error(), sqliteCheckPrefix); ok {
+		constraint, _, _ = strings.Cut(constraint, " ")
+
+		return &QueryError{
+			Code:       "23514",
+			Constraint: constraint,
+			Err:        err,
+		}
+	}
+
+	if _, columns, ok := strings.Cut(sqliteErr.Error(), sqliteUniquePrefix); ok {
+		columns, _, _ = strings.Cut(columns, " (")
+
+		// SQLite doesn't name the constraint, so a single column gets the
+		// name PostgreSQL gives to its UNIQUE constraint, like
+		// users_email_key.
+		constraint := columns
+		if !strings.Contains(columns, ",") {
+			constraint = strings.ReplaceAll(columns, ".", "_") + "_key"
+		}
+
+		return &QueryError{
+			Code:       "23505",
+			Constraint: constraint,
+			Err:        err,
+		}
+	}
+
+	if strings.Contains(sqliteErr.Error(), sqliteForeignKeyMessage) {
+		return &QueryError{
+			Code: "23503",
+			Err:  err,
+		}
+	}
+
+	return err
+}
+
+// isUndefinedTable reports whether err is the driver error for a table that
+// doesn't exist.
+func isUndefinedTable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "42P01"
+	}
+
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && strings.Contains(sqliteErr.Error(), "no such table")
+}
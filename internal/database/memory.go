@@ -0,0 +1,409 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"pilem/internal/data"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MemoryMovieStore is a MovieStore keeping the movies in memory, for tests
+// and demos. It behaves like MovieModel, including the CHECK constraints of
+// the movies table, except that Search only approximates the PostgreSQL
+// full-text ranking. It is safe for concurrent use.
+type MemoryMovieStore struct {
+	mu     sync.RWMutex
+	movies map[int64]data.Movie
+	lastID int64
+}
+
+// NewMemoryMovieStore returns an empty MemoryMovieStore.
+func NewMemoryMovieStore() *MemoryMovieStore {
+	return &MemoryMovieStore{movies: make(map[int64]data.Movie)}
+}
+
+func (s *MemoryMovieStore) Insert(ctx context.Context, movie *data.Movie) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := checkMovieConstraints(movie); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+
+	movie.ID = s.lastID
+	// The created_at column has a precision of one second.
+	movie.CreatedAt = time.Now().Truncate(time.Second)
+	movie.Version = 1
+
+	s.movies[movie.ID] = copyMovie(*movie)
+
+	return nil
+}
+
+func (s *MemoryMovieStore) InsertMany(ctx context.Context, movies []*data.Movie) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		if err := checkMovieConstraints(movie); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	createdAt := time.Now().Truncate(time.Second)
+
+	for _, movie := range movies {
+		s.lastID++
+
+		movie.ID = s.lastID
+		movie.CreatedAt = createdAt
+		movie.Version = 1
+
+		s.movies[movie.ID] = copyMovie(*movie)
+	}
+
+	return nil
+}
+
+func (s *MemoryMovieStore) Get(ctx context.Context, id int64) (*data.Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	movie, ok := s.movies[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	movie = copyMovie(movie)
+	return &movie, nil
+}
+
+func (s *MemoryMovieStore) Update(ctx context.Context, movie *data.Movie) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.movies[movie.ID]
+	if !ok || stored.Version != movie.Version {
+		return ErrEditConflict
+	}
+
+	if err := checkMovieConstraints(movie); err != nil {
+		return err
+	}
+
+	stored.Title = movie.Title
+	stored.Year = movie.Year
+	stored.Runtime = movie.Runtime
+	stored.Genres = slices.Clone(movie.Genres)
+	stored.Version++
+
+	s.movies[movie.ID] = stored
+	movie.Version = stored.Version
+
+	return nil
+}
+
+func (s *MemoryMovieStore) Delete(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.movies[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(s.movies, id)
+
+	return nil
+}
+
+// List returns a page of movies whose title contains title (case-insensitive)
+// and whose genres contain every one of genres. Empty title or genres match
+// every movie.
+func (s *MemoryMovieStore) List(ctx context.Context, title string, genres []string, filters data.Filters) ([]*data.Movie, data.Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	title = strings.ToLower(title)
+
+	var matches []*data.MovieSearchResult
+
+	s.mu.RLock()
+	for _, movie := range s.movies {
+		if strings.Contains(strings.ToLower(movie.Title), title) && containsAll(movie.Genres, genres) {
+			movie = copyMovie(movie)
+			matches = append(matches, &data.MovieSearchResult{Movie: &movie})
+		}
+	}
+	s.mu.RUnlock()
+
+	page, metadata := paginate(matches, filters)
+
+	movies := []*data.Movie{}
+	for _, result := range page {
+		movies = append(movies, result.Movie)
+	}
+
+	return movies, metadata, nil
+}
+
+// Search returns a page of movies whose title matches the full-text query q,
+// written in web search syntax, and whose genres contain every one of genres.
+// The sort column "relevance" orders by the rank of the match.
+func (s *MemoryMovieStore) Search(ctx context.Context, q string, genres []string, filters data.Filters) ([]*data.MovieSearchResult, data.Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	query := parseWebSearch(q)
+
+	var matches []*data.MovieSearchResult
+
+	s.mu.RLock()
+	for _, movie := range s.movies {
+		rank, ok := query.match(searchWords(movie.Title))
+		if ok && containsAll(movie.Genres, genres) {
+			movie = copyMovie(movie)
+			matches = append(matches, &data.MovieSearchResult{Movie: &movie, Rank: rank})
+		}
+	}
+	s.mu.RUnlock()
+
+	page, metadata := paginate(matches, filters)
+
+	return page, metadata, nil
+}
+
+// copyMovie returns movie with its own copy of the genres, so the stored
+// movies can't be changed through the ones handed out.
+func copyMovie(movie data.Movie) data.Movie {
+	movie.Genres = slices.Clone(movie.Genres)
+	return movie
+}
+
+// checkMovieConstraints mirrors the CHECK constraints of the movies table,
+// checked in the same order as PostgreSQL does: by constraint name.
+func checkMovieConstraints(movie *data.Movie) error {
+	// array_length of an empty array is NULL, which passes the check.
+	if len(movie.Genres) > 5 {
+		return checkViolation("genres_length_check")
+	}
+
+	if movie.Runtime < 0 {
+		return checkViolation("movies_runtime_check")
+	}
+
+	if movie.Year < 1888 || movie.Year > int32(time.Now().Year()) {
+		return checkViolation("movies_year_check")
+	}
+
+	return nil
+}
+
+// checkViolation returns the error of MovieModel for a violated CHECK
+// constraint.
+func checkViolation(constraint string) error {
+	return &QueryError{
+		Code:       "23514",
+		Constraint: constraint,
+		Err:        fmt.Errorf("new row for relation \"movies\" violates check constraint %q", constraint),
+	}
+}
+
+// containsAll reports whether genres contains every one of want, like the
+// @> array operator.
+func containsAll(genres, want []string) bool {
+	for _, genre := range want {
+		if !slices.Contains(genres, genre) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// paginate sorts results like the ORDER BY of MovieModel and returns the page
+// selected by filters.
+func paginate(results []*data.MovieSearchResult, filters data.Filters) ([]*data.MovieSearchResult, data.Metadata) {
+	column := filters.SortColumn()
+	desc := filters.SortDirection() == "DESC"
+
+	slices.SortFunc(results, func(a, b *data.MovieSearchResult) int {
+		var c int
+
+		switch column {
+		case "id":
+			c = cmp.Compare(a.ID, b.ID)
+		case "title":
+			c = strings.Compare(a.Title, b.Title)
+		case "year":
+			c = cmp.Compare(a.Year, b.Year)
+		case "runtime":
+			c = cmp.Compare(a.Runtime, b.Runtime)
+		case "relevance":
+			c = cmp.Compare(a.Rank, b.Rank)
+		}
+
+		if desc {
+			c = -c
+		}
+
+		// Ties are ordered by id, always ascending.
+		return cmp.Or(c, cmp.Compare(a.ID, b.ID))
+	})
+
+	start := min(filters.Offset(), len(results))
+	end := min(start+filters.Limit(), len(results))
+	page := results[start:end]
+
+	// The total comes from count(*) OVER() in MovieModel, which is only
+	// available on the rows returned: a page past the end has no metadata.
+	totalRecords := 0
+	if len(page) > 0 {
+		totalRecords = len(results)
+	}
+
+	if page == nil {
+		page = []*data.MovieSearchResult{}
+	}
+
+	return page, data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+}
+
+// searchWords splits text into lowercase words, like the simple text search
+// configuration does.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchTerm is a word, or a phrase of consecutive words, of a web search.
+type searchTerm struct {
+	words  []string
+	negate bool
+}
+
+// webSearch is a parsed web search query: it matches when every term of one
+// of its clauses matches.
+type webSearch [][]searchTerm
+
+// parseWebSearch parses q like websearch_to_tsquery: words are ANDed, quoted
+// text is a phrase, a leading "-" negates a word or phrase and "or" separates
+// alternatives.
+func parseWebSearch(q string) webSearch {
+	query := webSearch{nil}
+	negate := false
+
+	for q != "" {
+		r, size := utf8.DecodeRuneInString(q)
+
+		switch {
+		case unicode.IsSpace(r):
+			q = q[size:]
+			continue
+		case r == '-':
+			negate = true
+			q = q[size:]
+			continue
+		}
+
+		var text string
+
+		if r == '"' {
+			text, q, _ = strings.Cut(q[1:], `"`)
+		} else {
+			end := strings.IndexFunc(q, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+			if end < 0 {
+				end = len(q)
+			}
+			text, q = q[:end], q[end:]
+
+			if strings.EqualFold(text, "or") {
+				if len(query[len(query)-1]) > 0 {
+					query = append(query, nil)
+				}
+				negate = false
+				continue
+			}
+		}
+
+		if words := searchWords(text); len(words) > 0 {
+			last := len(query) - 1
+			query[last] = append(query[last], searchTerm{words: words, negate: negate})
+		}
+		negate = false
+	}
+
+	return query
+}
+
+// match reports whether title, split into words, matches the query and
+// ranks the match by the share of the title matched by the query words.
+func (query webSearch) match(title []string) (float32, bool) {
+	for _, clause := range query {
+		if len(clause) == 0 {
+			continue
+		}
+
+		matched, ok := 0, true
+		for _, term := range clause {
+			n := countPhrase(title, term.words)
+			if (n > 0) == term.negate {
+				ok = false
+				break
+			}
+			matched += n * len(term.words)
+		}
+
+		if ok && len(title) > 0 {
+			return float32(matched) / float32(len(title)), true
+		}
+		if ok {
+			return 0, true
+		}
+	}
+
+	return 0, false
+}
+
+// countPhrase returns how many times phrase appears in words.
+func countPhrase(words, phrase []string) int {
+	n := 0
+
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		if slices.Equal(words[i:i+len(phrase)], phrase) {
+			n++
+		}
+	}
+
+	return n
+}
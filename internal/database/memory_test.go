@@ -0,0 +1,51 @@
+package database_test
+
+import (
+	"context"
+	"pilem/internal/data"
+	"pilem/internal/database"
+	"pilem/internal/database/storetest"
+	"testing"
+)
+
+func TestMemoryMovieStore(t *testing.T) {
+	t.Parallel()
+
+	storetest.Run(t, func(t *testing.T) database.MovieStore {
+		return database.NewMemoryMovieStore()
+	})
+}
+
+func TestMemoryMovieStore_StoredMovieIsNotShared(t *testing.T) {
+	t.Parallel()
+
+	s := database.NewMemoryMovieStore()
+
+	movie := &data.Movie{Title: "Overlord", Year: 2018, Runtime: 110, Genres: []string{"Action"}}
+	if err := s.Insert(context.Background(), movie); err != nil {
+		t.Fatal(err)
+	}
+	movie.Genres[0] = "Comedy"
+
+	got, err := s.Get(context.Background(), movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Genres[0] = "Horror"
+
+	got, err = s.Get(context.Background(), movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Genres[0] != "Action" {
+		t.Errorf("want stored genre Action got %s", got.Genres[0])
+	}
+}
+
+func TestMemoryModels(t *testing.T) {
+	t.Parallel()
+
+	runAccountTests(t, func(t *testing.T) database.Models {
+		return database.NewMemoryModels()
+	})
+}
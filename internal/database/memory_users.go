@@ -0,0 +1,267 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"pilem/internal/data"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryPermissions are the permission codes seeded by the migrations.
+var memoryPermissions = []string{
+	data.PermissionMoviesRead,
+	data.PermissionMoviesWrite,
+	data.PermissionMetricsRead,
+}
+
+// memoryAccounts holds the users, tokens and permissions of the models
+// returned by NewMemoryModels, shared by their stores like the tables they
+// replace.
+type memoryAccounts struct {
+	mu     sync.RWMutex
+	users  map[int64]data.User
+	lastID int64
+	// emails maps the lowercase email of every user to its id, since the
+	// emails are unique regardless of case.
+	emails      map[string]int64
+	tokens      map[[sha256.Size]byte]data.Token
+	permissions map[int64][]string
+}
+
+// NewMemoryModels returns models keeping everything in memory, lost on
+// restart, for tests and demos. They behave like the models of NewModels,
+// except that WithTx doesn't make the changes of fn all or nothing.
+func NewMemoryModels() Models {
+	accounts := &memoryAccounts{
+		users:       make(map[int64]data.User),
+		emails:      make(map[string]int64),
+		tokens:      make(map[[sha256.Size]byte]data.Token),
+		permissions: make(map[int64][]string),
+	}
+
+	return Models{
+		Movies:      NewMemoryMovieStore(),
+		Permissions: memoryPermissionStore{accounts},
+		Tokens:      memoryTokenStore{accounts},
+		Users:       memoryUserStore{accounts},
+	}
+}
+
+// copyUser returns a copy of user sharing nothing with it, without the
+// plaintext password, which isn't stored.
+func copyUser(user data.User) data.User {
+	user.Password = data.Password{Hash: slices.Clone(user.Password.Hash)}
+	return user
+}
+
+// foreignKeyViolation returns the error of the models of NewModels for a
+// row of table referencing a user that doesn't exist.
+func foreignKeyViolation(table, constraint string) error {
+	return &QueryError{
+		Code:       "23503",
+		Constraint: constraint,
+		Err:        fmt.Errorf("insert or update on table %q violates foreign key constraint %q", table, constraint),
+	}
+}
+
+// memoryUserStore is the UserStore of NewMemoryModels.
+type memoryUserStore struct {
+	*memoryAccounts
+}
+
+func (s memoryUserStore) Insert(ctx context.Context, user *data.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	email := strings.ToLower(user.Email)
+	if _, ok := s.emails[email]; ok {
+		return ErrDuplicateEmail
+	}
+
+	s.lastID++
+
+	user.ID = s.lastID
+	// The created_at column has a precision of one second.
+	user.CreatedAt = time.Now().Truncate(time.Second)
+	user.Version = 1
+
+	s.users[user.ID] = copyUser(*user)
+	s.emails[email] = user.ID
+
+	return nil
+}
+
+func (s memoryUserStore) GetByEmail(ctx context.Context, email string) (*data.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.emails[strings.ToLower(email)]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	user := copyUser(s.users[id])
+	return &user, nil
+}
+
+func (s memoryUserStore) Update(ctx context.Context, user *data.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[user.ID]
+	if !ok || stored.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	email := strings.ToLower(user.Email)
+	if id, ok := s.emails[email]; ok && id != user.ID {
+		return ErrDuplicateEmail
+	}
+
+	delete(s.emails, strings.ToLower(stored.Email))
+	s.emails[email] = user.ID
+
+	user.Version++
+
+	stored = copyUser(*user)
+	stored.CreatedAt = s.users[user.ID].CreatedAt
+	s.users[user.ID] = stored
+
+	return nil
+}
+
+// GetForToken returns the user owning the unexpired token of scope whose
+// plaintext is tokenPlaintext.
+func (s memoryUserStore) GetForToken(ctx context.Context, scope, tokenPlaintext string) (*data.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[sha256.Sum256([]byte(tokenPlaintext))]
+	if !ok || token.Scope != scope || !token.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	user, ok := s.users[token.UserID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	user = copyUser(user)
+	return &user, nil
+}
+
+// memoryTokenStore is the TokenStore of NewMemoryModels.
+type memoryTokenStore struct {
+	*memoryAccounts
+}
+
+// New generates a token for userID and stores it.
+func (s memoryTokenStore) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*data.Token, error) {
+	token, err := data.GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Insert(ctx, token)
+	return token, err
+}
+
+func (s memoryTokenStore) Insert(ctx context.Context, token *data.Token) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Like the foreign key of the tokens table.
+	if _, ok := s.users[token.UserID]; !ok {
+		return foreignKeyViolation("tokens", "tokens_user_id_fkey")
+	}
+
+	stored := *token
+	stored.Plaintext = ""
+	stored.Hash = slices.Clone(token.Hash)
+
+	s.tokens[[sha256.Size]byte(token.Hash)] = stored
+
+	return nil
+}
+
+// DeleteAllForUser deletes every token of scope that belongs to userID.
+func (s memoryTokenStore) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, token := range s.tokens {
+		if token.Scope == scope && token.UserID == userID {
+			delete(s.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+// memoryPermissionStore is the PermissionStore of NewMemoryModels.
+type memoryPermissionStore struct {
+	*memoryAccounts
+}
+
+// GetAllForUser returns the permission codes granted to userID.
+func (s memoryPermissionStore) GetAllForUser(ctx context.Context, userID int64) (data.Permissions, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.permissions[userID]), nil
+}
+
+// AddForUser grants the permissions with the given codes to userID. Unknown
+// codes are ignored, like the ones missing from the permissions table.
+func (s memoryPermissionStore) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return foreignKeyViolation("users_permissions", "users_permissions_user_id_fkey")
+	}
+
+	for _, code := range codes {
+		if slices.Contains(memoryPermissions, code) && !slices.Contains(s.permissions[userID], code) {
+			s.permissions[userID] = append(s.permissions[userID], code)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,403 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by the Migrator.
+var (
+	ErrDirtyMigration     = errors.New("database is dirty, fix the failed migration then force its version")
+	ErrEmptyDownMigration = errors.New("empty down migration")
+	ErrUnknownMigration   = errors.New("unknown migration version")
+)
+
+// migrationLockID is the key of the advisory lock held while migrating, so
+// replicas starting at the same time don't run the same migration twice.
+const migrationLockID = 7356_1064_2019
+
+// migrationFileRX matches the migration file names, like
+// 000001_create_movies_table.up.sql.
+var migrationFileRX = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// MigrationStatus compares the schema version recorded in the database with
+// the migrations available.
+type MigrationStatus struct {
+	// Version is the version of the last applied migration, 0 if none.
+	Version int64 `json:"version"`
+	// Dirty is true when the last migration failed halfway.
+	Dirty bool `json:"dirty"`
+	// Latest is the version of the last available migration.
+	Latest int64 `json:"latest"`
+	// Pending is the number of available migrations not applied yet.
+	Pending int `json:"pending"`
+	// EmptyDown lists the versions whose down migration is empty, so they
+	// can't be rolled back.
+	EmptyDown []int64 `json:"empty_down,omitempty"`
+}
+
+// migration is a pair of up and down migration files.
+type migration struct {
+	version int64
+	name    string
+	up      string
+	down    string
+}
+
+// readMigrations returns the migrations in fsys, in ascending order of
+// version.
+func readMigrations(fsys fs.FS) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+
+	// ReadDir returns the entries sorted by name, and the versions are zero
+	// padded, so they come in ascending order with the down file first.
+	for _, entry := range entries {
+		m := migrationFileRX.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		if n := len(migrations); n == 0 || migrations[n-1].version != version {
+			migrations = append(migrations, migration{version: version, name: m[2]})
+		}
+
+		mig := &migrations[len(migrations)-1]
+		if mig.name != m[2] {
+			return nil, fmt.Errorf("migration version %d is used by %s and %s", version, mig.name, m[2])
+		}
+
+		if m[3] == "up" {
+			mig.up = string(body)
+		} else {
+			mig.down = string(body)
+		}
+	}
+
+	for _, mig := range migrations {
+		if strings.TrimSpace(mig.up) == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up migration", mig.version, mig.name)
+		}
+	}
+
+	return migrations, nil
+}
+
+// isEmptySQL reports whether query holds nothing but blanks and comments.
+func isEmptySQL(query string) bool {
+	for _, line := range strings.Split(query, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rowQuerier is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// readSchemaVersion reads the version from the schema_migrations table, as
+// written by golang-migrate.
+func readSchemaVersion(ctx context.Context, q rowQuerier) (version int64, dirty bool, err error) {
+	query := `
+	SELECT version, dirty
+	FROM schema_migrations
+	LIMIT 1
+	`
+
+	// No row or no table at all both mean that nothing was applied yet.
+	err = q.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) && !isUndefinedTable(err) {
+		return 0, false, translateError(err)
+	}
+
+	return version, dirty, nil
+}
+
+// migrationStatus compares version with migrations.
+func migrationStatus(version int64, dirty bool, migrations []migration) MigrationStatus {
+	status := MigrationStatus{Version: version, Dirty: dirty}
+
+	for _, mig := range migrations {
+		if mig.version > status.Version {
+			status.Pending++
+		}
+		if isEmptySQL(mig.down) {
+			status.EmptyDown = append(status.EmptyDown, mig.version)
+		}
+		status.Latest = mig.version
+	}
+
+	return status
+}
+
+// GetMigrationStatus reads the schema version from the schema_migrations
+// table, as written by golang-migrate, and compares it with the migrations in
+// fsys.
+func GetMigrationStatus(ctx context.Context, db *sql.DB, fsys fs.FS) (MigrationStatus, error) {
+	migrations, err := readMigrations(fsys)
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	version, dirty, err := readSchemaVersion(ctx, db)
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	return migrationStatus(version, dirty, migrations), nil
+}
+
+// Migrator applies the migrations of an fs.FS to the database. It keeps the
+// version in the schema_migrations table the way golang-migrate does, so
+// both can be used on the same database.
+//
+// Each migration runs in a transaction along with the version update, so a
+// failing migration leaves the database as it was. A dirty version can only
+// come from a migration run by another tool, and must be fixed by hand then
+// cleared with Force.
+type Migrator struct {
+	db     *sql.DB
+	driver string
+	fsys   fs.FS
+	logger *slog.Logger
+}
+
+// NewMigrator returns a Migrator applying the migrations in fsys to db,
+// opened with driver "postgres" or "sqlite".
+func NewMigrator(db *sql.DB, driver string, fsys fs.FS, logger *slog.Logger) *Migrator {
+	return &Migrator{db: db, driver: driver, fsys: fsys, logger: logger}
+}
+
+// Status returns the migration status of the database.
+func (m *Migrator) Status(ctx context.Context) (MigrationStatus, error) {
+	return GetMigrationStatus(ctx, m.db, m.fsys)
+}
+
+// Up applies every pending migration and returns how many were applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	var applied int
+
+	err := m.withLock(ctx, func(conn *sql.Conn, migrations []migration) error {
+		version, err := m.cleanVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range migrations {
+			if mig.version <= version {
+				continue
+			}
+
+			err := m.run(ctx, conn, mig.up, mig.version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", mig.version, mig.name, err)
+			}
+
+			m.logger.Info("migration applied", "version", mig.version, "name", mig.name)
+			applied++
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back the last n applied migrations and returns how many were
+// rolled back. It stops before a migration whose down file is empty.
+func (m *Migrator) Down(ctx context.Context, n int) (int, error) {
+	var rolledBack int
+
+	err := m.withLock(ctx, func(conn *sql.Conn, migrations []migration) error {
+		version, err := m.cleanVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && rolledBack < n && version > 0; i-- {
+			mig := migrations[i]
+			if mig.version > version {
+				continue
+			}
+			if mig.version < version {
+				return fmt.Errorf("%w: %d", ErrUnknownMigration, version)
+			}
+
+			if isEmptySQL(mig.down) {
+				return fmt.Errorf("migration %d_%s: %w", mig.version, mig.name, ErrEmptyDownMigration)
+			}
+
+			var previous int64
+			if i > 0 {
+				previous = migrations[i-1].version
+			}
+
+			err := m.run(ctx, conn, mig.down, previous)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", mig.version, mig.name, err)
+			}
+
+			m.logger.Info("migration rolled back", "version", mig.version, "name", mig.name)
+			version = previous
+			rolledBack++
+		}
+
+		if rolledBack < n && version > 0 {
+			return fmt.Errorf("%w: %d", ErrUnknownMigration, version)
+		}
+
+		return nil
+	})
+
+	return rolledBack, err
+}
+
+// Force sets the version of the database and clears the dirty flag, without
+// running any migration. Version 0 means that no migration is applied.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	return m.withLock(ctx, func(conn *sql.Conn, migrations []migration) error {
+		if version != 0 && !hasMigration(migrations, version) {
+			return fmt.Errorf("%w: %d", ErrUnknownMigration, version)
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		err = setSchemaVersion(ctx, tx, version)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	})
+}
+
+// withLock reads the migrations then runs fn holding the migration lock. The
+// advisory lock belongs to the session, so everything runs on conn. SQLite
+// has no such lock, but its pool has a single connection, which conn holds.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn, migrations []migration) error) error {
+	migrations, err := readMigrations(m.fsys)
+	if err != nil {
+		return err
+	}
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.driver != "sqlite" {
+		_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID)
+		if err != nil {
+			return translateError(err)
+		}
+		// Unlock even when ctx is done, or the session would keep the lock.
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+	}
+
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint NOT NULL PRIMARY KEY,
+		dirty boolean NOT NULL
+	)`
+
+	_, err = conn.ExecContext(ctx, query)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return fn(conn, migrations)
+}
+
+// cleanVersion returns the version of the database, or ErrDirtyMigration.
+func (m *Migrator) cleanVersion(ctx context.Context, conn *sql.Conn) (int64, error) {
+	version, dirty, err := readSchemaVersion(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	if dirty {
+		return 0, fmt.Errorf("%w: version %d", ErrDirtyMigration, version)
+	}
+
+	return version, nil
+}
+
+// run runs query and records version in the same transaction.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, query string, version int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Without arguments the query is sent as is, so it can hold several
+	// statements.
+	_, err = tx.ExecContext(ctx, query)
+	if err != nil {
+		return translateError(err)
+	}
+
+	err = setSchemaVersion(ctx, tx, version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setSchemaVersion replaces the row of schema_migrations with a clean
+// version, or leaves the table empty for version 0.
+func setSchemaVersion(ctx context.Context, tx *sql.Tx, version int64) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return translateError(err)
+	}
+
+	if version == 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, version)
+	return translateError(err)
+}
+
+func hasMigration(migrations []migration, version int64) bool {
+	for _, mig := range migrations {
+		if mig.version == version {
+			return true
+		}
+	}
+
+	return false
+}
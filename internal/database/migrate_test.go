@@ -0,0 +1,163 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"pilem/helper"
+	"pilem/internal/database"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/go-cmp/cmp"
+)
+
+var testMigrations = fstest.MapFS{
+	"000001_create_movies_table.up.sql":   {Data: []byte("CREATE TABLE movies (id bigserial PRIMARY KEY);")},
+	"000001_create_movies_table.down.sql": {Data: []byte("-- nothing to do\n")},
+	"000002_add_title.up.sql":             {Data: []byte("ALTER TABLE movies ADD COLUMN title text;")},
+	"000002_add_title.down.sql":           {Data: []byte("ALTER TABLE movies DROP COLUMN title;")},
+	"README.md":                           {Data: []byte("not a migration")},
+}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// expectLock expects the migrator to take the lock, create the version table
+// and read the version.
+func expectLock(mock sqlmock.Sqlmock, version int64, dirty bool) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, dirty FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(version, dirty))
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestMigratorUp_ApplyPendingMigrations(t *testing.T) {
+	t.Parallel()
+
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		expectLock(mock, 1, false)
+		mock.ExpectBegin()
+		mock.ExpectExec("ALTER TABLE movies ADD COLUMN title text").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DELETE FROM schema_migrations").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectUnlock(mock)
+	})
+
+	m := database.NewMigrator(db, "postgres", testMigrations, discardLogger)
+
+	applied, err := m.Up(context.Background())
+	if err != nil {
+		t.Fatalf("can't apply migrations. Err: %v", err)
+	}
+
+	if applied != 1 {
+		t.Errorf("want 1 migration applied got %d", applied)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled query expectations: %s", err)
+	}
+}
+
+func TestMigratorUp_RollbackFailedMigration(t *testing.T) {
+	t.Parallel()
+
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		expectLock(mock, 1, false)
+		mock.ExpectBegin()
+		mock.ExpectExec("ALTER TABLE movies ADD COLUMN title text").WillReturnError(errors.New("syntax error"))
+		mock.ExpectRollback()
+		expectUnlock(mock)
+	})
+
+	m := database.NewMigrator(db, "postgres", testMigrations, discardLogger)
+
+	_, err := m.Up(context.Background())
+	if err == nil {
+		t.Fatal("want error got nil")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled query expectations: %s", err)
+	}
+}
+
+func TestMigratorUp_RefuseDirtyDatabase(t *testing.T) {
+	t.Parallel()
+
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		expectLock(mock, 2, true)
+		expectUnlock(mock)
+	})
+
+	m := database.NewMigrator(db, "postgres", testMigrations, discardLogger)
+
+	_, err := m.Up(context.Background())
+	if !errors.Is(err, database.ErrDirtyMigration) {
+		t.Errorf("want ErrDirtyMigration got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled query expectations: %s", err)
+	}
+}
+
+func TestMigratorDown_StopAtEmptyDownMigration(t *testing.T) {
+	t.Parallel()
+
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		expectLock(mock, 2, false)
+		mock.ExpectBegin()
+		mock.ExpectExec("ALTER TABLE movies DROP COLUMN title").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DELETE FROM schema_migrations").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectUnlock(mock)
+	})
+
+	m := database.NewMigrator(db, "postgres", testMigrations, discardLogger)
+
+	rolledBack, err := m.Down(context.Background(), 2)
+	if !errors.Is(err, database.ErrEmptyDownMigration) {
+		t.Errorf("want ErrEmptyDownMigration got %v", err)
+	}
+
+	if rolledBack != 1 {
+		t.Errorf("want 1 migration rolled back got %d", rolledBack)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled query expectations: %s", err)
+	}
+}
+
+func TestGetMigrationStatus_NothingApplied(t *testing.T) {
+	t.Parallel()
+
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("SELECT version, dirty FROM schema_migrations").
+			WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}))
+	})
+
+	got, err := database.GetMigrationStatus(context.Background(), db, testMigrations)
+	if err != nil {
+		t.Fatalf("can't get migration status. Err: %v", err)
+	}
+
+	want := database.MigrationStatus{Latest: 2, Pending: 2, EmptyDown: []int64{1}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("migration status mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled query expectations: %s", err)
+	}
+}
@@ -1,21 +1,184 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"math/rand/v2"
+	"time"
 )
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	ErrDuplicateEmail = errors.New("duplicate email")
 )
 
+// DefaultQueryTimeout is the query timeout of a model whose Timeout is 0.
+const DefaultQueryTimeout = 3 * time.Second
+
+// maxTxAttempts is how many times WithTx runs a transaction failing with a
+// serialization failure.
+const maxTxAttempts = 4
+
+// txRetryDelay is the delay before the first retry of a transaction, doubled
+// at each retry.
+const txRetryDelay = 10 * time.Millisecond
+
+// DBTX is implemented by *sql.DB and *sql.Tx, so a model runs its queries
+// either on its own or as part of a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type Models struct {
-	Movies MovieModel
+	Movies      MovieStore
+	Permissions PermissionStore
+	Tokens      TokenStore
+	Users       UserStore
+
+	// db begins the transactions of WithTx. It is nil in the models given to
+	// the function of WithTx, which are already in a transaction.
+	db *sql.DB
+}
+
+// NewModels returns the models using db, each query bounded by
+// queryTimeout.
+func NewModels(db *sql.DB, queryTimeout time.Duration) Models {
+	return Models{
+		Movies:      MovieModel{DB: db, Timeout: queryTimeout},
+		Permissions: PermissionModel{DB: db, Timeout: queryTimeout},
+		Tokens:      TokenModel{DB: db, Timeout: queryTimeout},
+		Users:       UserModel{DB: db, Timeout: queryTimeout},
+		db:          db,
+	}
 }
 
-func NewModels(db *sql.DB) Models {
+// NewSQLiteModels returns the models using the SQLite database db, migrated
+// with migrations.SQLiteFS, each query bounded by queryTimeout.
+func NewSQLiteModels(db *sql.DB, queryTimeout time.Duration) Models {
 	return Models{
-		Movies: MovieModel{DB: db},
+		Movies:      SQLiteMovieModel{DB: db, Timeout: queryTimeout},
+		Permissions: SQLitePermissionModel{DB: db, Timeout: queryTimeout},
+		Tokens:      SQLiteTokenModel{DB: db, Timeout: queryTimeout},
+		Users:       SQLiteUserModel{DB: db, Timeout: queryTimeout},
+		db:          db,
+	}
+}
+
+// WithTx calls fn with the models bound to a transaction, committed when fn
+// returns nil and rolled back when it returns an error or panics.
+//
+// The whole transaction is retried with a growing delay when it fails with
+// ErrSerializationFailure, so fn must be safe to call more than once: it must
+// not depend on what a previous call changed, like the version of a record
+// it updated. PostgreSQL only reports serialization failures at the
+// repeatable read and serializable isolation levels, set in opts. Called
+// on models not returned by NewModels or NewSQLiteModels, like the ones given
+// to fn, WithTx calls fn directly. The movies of a MemoryMovieStore aren't
+// part of the transaction.
+func (m Models) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Models) error) error {
+	if m.db == nil {
+		return fn(m)
+	}
+
+	delay := txRetryDelay
+
+	for attempt := 1; ; attempt++ {
+		err := m.runTx(ctx, opts, fn)
+		if !errors.Is(err, ErrSerializationFailure) || attempt == maxTxAttempts {
+			return err
+		}
+
+		// Add up to 50% of jitter, so the conflicting transactions don't
+		// retry in lockstep.
+		timer := time.NewTimer(delay + rand.N(delay/2))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		delay *= 2
+	}
+}
+
+// runTx runs fn once in a transaction.
+func (m Models) runTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Models) error) (err error) {
+	tx, err := m.db.BeginTx(ctx, opts)
+	if err != nil {
+		return translateError(err)
+	}
+
+	defer func() {
+		if pv := recover(); pv != nil {
+			tx.Rollback()
+			panic(pv)
+		}
+	}()
+
+	err = fn(m.bind(tx))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return translateError(tx.Commit())
+}
+
+// bind returns a copy of the models running their queries on tx.
+func (m Models) bind(tx *sql.Tx) Models {
+	switch movies := m.Movies.(type) {
+	case MovieModel:
+		movies.DB = tx
+		m.Movies = movies
+	case SQLiteMovieModel:
+		movies.DB = tx
+		m.Movies = movies
+	}
+
+	switch permissions := m.Permissions.(type) {
+	case PermissionModel:
+		permissions.DB = tx
+		m.Permissions = permissions
+	case SQLitePermissionModel:
+		permissions.DB = tx
+		m.Permissions = permissions
 	}
+
+	switch tokens := m.Tokens.(type) {
+	case TokenModel:
+		tokens.DB = tx
+		m.Tokens = tokens
+	case SQLiteTokenModel:
+		tokens.DB = tx
+		m.Tokens = tokens
+	}
+
+	switch users := m.Users.(type) {
+	case UserModel:
+		users.DB = tx
+		m.Users = users
+	case SQLiteUserModel:
+		users.DB = tx
+		m.Users = users
+	}
+
+	m.db = nil
+
+	return m
+}
+
+// withTimeout returns a copy of ctx cancelled after timeout, or after
+// DefaultQueryTimeout when timeout is 0. A done ctx, like the one of a request
+// whose client went away, still cancels the query right away.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+
+	return context.WithTimeout(ctx, timeout)
 }
@@ -0,0 +1,160 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"pilem/helper"
+	"pilem/internal/data"
+	"pilem/internal/database"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestModelsWithTx_CommitQueriesInTransaction(t *testing.T) {
+	t.Parallel()
+
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO users_permissions").
+			WithArgs(1, pq.Array([]string{data.PermissionMoviesRead})).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM tokens").
+			WithArgs(data.ScopeActivation, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	})
+
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+	err := m.WithTx(context.Background(), nil, func(tx database.Models) error {
+		err := tx.Permissions.AddForUser(context.Background(), 1, data.PermissionMoviesRead)
+		if err != nil {
+			return err
+		}
+
+		// Nested calls run in the same transaction.
+		return tx.WithTx(context.Background(), nil, func(tx database.Models) error {
+			return tx.Tokens.DeleteAllForUser(context.Background(), data.ScopeActivation, 1)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestModelsWithTx_RetrySerializationFailure(t *testing.T) {
+	t.Parallel()
+
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM tokens").WillReturnError(&pq.Error{Code: "40001"})
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM tokens").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit().WillReturnError(&pq.Error{Code: "40001"})
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM tokens").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	})
+
+	calls := 0
+
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+	err := m.WithTx(context.Background(), nil, func(tx database.Models) error {
+		calls++
+		return tx.Tokens.DeleteAllForUser(context.Background(), data.ScopeActivation, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 3 {
+		t.Errorf("want 3 calls got %d", calls)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestModelsWithTx_GiveUpAfterRepeatedSerializationFailures(t *testing.T) {
+	t.Parallel()
+
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.MatchExpectationsInOrder(false)
+		for range 10 {
+			mock.ExpectBegin()
+			mock.ExpectRollback()
+		}
+	})
+
+	calls := 0
+
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+	err := m.WithTx(context.Background(), nil, func(tx database.Models) error {
+		calls++
+		return &database.QueryError{Code: "40001", Err: errors.New("could not serialize access")}
+	})
+
+	if !errors.Is(err, database.ErrSerializationFailure) {
+		t.Errorf("want ErrSerializationFailure got %v", err)
+	}
+
+	if calls < 2 || calls >= 10 {
+		t.Errorf("want a few retries got %d calls", calls)
+	}
+}
+
+func TestModelsWithTx_RollbackOnError(t *testing.T) {
+	t.Parallel()
+
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+	})
+
+	want := errors.New("boom")
+
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+	err := m.WithTx(context.Background(), nil, func(tx database.Models) error {
+		return want
+	})
+
+	if !errors.Is(err, want) {
+		t.Errorf("want %v got %v", want, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestModelsWithTx_RollbackOnPanic(t *testing.T) {
+	t.Parallel()
+
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+	})
+
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+
+	defer func() {
+		if pv := recover(); pv != "boom" {
+			t.Errorf("want panic boom got %v", pv)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	m.WithTx(context.Background(), nil, func(tx database.Models) error {
+		panic("boom")
+	})
+}
@@ -1,20 +1,47 @@
 package database
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"pilem/internal/data"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
 )
 
+// MovieStore stores the movies. Get and Delete return ErrRecordNotFound for
+// a missing movie, and Update returns ErrEditConflict when the movie is
+// missing or its version changed since it was read. MovieModel is the
+// PostgreSQL implementation and MemoryMovieStore the in-memory one.
+//
+// InsertMany inserts the movies like Insert, in order, and either inserts all
+// of them or none. The database stores insert more than a thousand movies
+// with several statements, which are only all or none in a transaction of
+// Models.WithTx.
+type MovieStore interface {
+	Insert(ctx context.Context, movie *data.Movie) error
+	InsertMany(ctx context.Context, movies []*data.Movie) error
+	Get(ctx context.Context, id int64) (*data.Movie, error)
+	Update(ctx context.Context, movie *data.Movie) error
+	Delete(ctx context.Context, id int64) error
+	List(ctx context.Context, title string, genres []string, filters data.Filters) ([]*data.Movie, data.Metadata, error)
+	Search(ctx context.Context, q string, genres []string, filters data.Filters) ([]*data.MovieSearchResult, data.Metadata, error)
+}
+
+// MovieModel is the MovieStore backed by the movies table.
 type MovieModel struct {
-	DB *sql.DB
+	DB DBTX
+	// Timeout bounds every query, on top of the caller context.
+	// DefaultQueryTimeout is used when it is 0.
+	Timeout time.Duration
 }
 
-func (m MovieModel) Insert(movie *data.Movie) error {
+func (m MovieModel) Insert(ctx context.Context, movie *data.Movie) error {
 	query := `
 	INSERT INTO movies (title, year, runtime, genres)
 	VALUES ($1, $2, $3, $4)
@@ -23,13 +50,108 @@ func (m MovieModel) Insert(movie *data.Movie) error {
 
 	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// insertBatchSize is the number of movies inserted by a single statement of
+// InsertMany, keeping the 4 parameters of each well below the limit of both
+// PostgreSQL and SQLite.
+const insertBatchSize = 1000
+
+func (m MovieModel) InsertMany(ctx context.Context, movies []*data.Movie) error {
+	for batch := range slices.Chunk(movies, insertBatchSize) {
+		err := m.insertBatch(ctx, batch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertBatch inserts movies with a single multi-row insert.
+func (m MovieModel) insertBatch(ctx context.Context, movies []*data.Movie) error {
+	var (
+		values strings.Builder
+		args   = make([]any, 0, 4*len(movies))
+	)
+
+	for i, movie := range movies {
+		if i > 0 {
+			values.WriteString(", ")
+		}
+		n := len(args)
+		fmt.Fprintf(&values, "($%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4)
+
+		args = append(args, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres))
+	}
+
+	query := `
+	INSERT INTO movies (title, year, runtime, genres)
+	VALUES ` + values.String() + `
+	RETURNING id, created_at, version
+	`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return translateError(err)
+	}
+	defer rows.Close()
+
+	inserted := make([]data.Movie, 0, len(movies))
+
+	for rows.Next() {
+		var movie data.Movie
+
+		err := rows.Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+		if err != nil {
+			return err
+		}
+
+		inserted = append(inserted, movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return translateError(err)
+	}
+
+	return setInserted(movies, inserted)
+}
+
+// setInserted sets the id, created_at and version of movies from the rows
+// returned by their multi-row insert. The order of the rows isn't
+// guaranteed, but the ids are given in the order of the values, so they are
+// matched by ascending id.
+func setInserted(movies []*data.Movie, inserted []data.Movie) error {
+	if len(inserted) != len(movies) {
+		return fmt.Errorf("inserted %d movies out of %d", len(inserted), len(movies))
+	}
+
+	slices.SortFunc(inserted, func(a, b data.Movie) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	for i, movie := range movies {
+		movie.ID = inserted[i].ID
+		movie.CreatedAt = inserted[i].CreatedAt
+		movie.Version = inserted[i].Version
+	}
+
+	return nil
 }
 
-func (m MovieModel) Get(id int64) (*data.Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int64) (*data.Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -42,7 +164,7 @@ func (m MovieModel) Get(id int64) (*data.Movie, error) {
 
 	var movie data.Movie
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
@@ -60,14 +182,132 @@ func (m MovieModel) Get(id int64) (*data.Movie, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, translateError(err)
 		}
 	}
 
 	return &movie, nil
 }
 
-func (m MovieModel) Delete(id int64) error {
+// likeEscaper escapes the LIKE wildcards so a title filter is always matched
+// literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// List returns a page of movies whose title contains title (case-insensitive)
+// and whose genres contain every one of genres. Empty title or genres match
+// every movie.
+func (m MovieModel) List(ctx context.Context, title string, genres []string, filters data.Filters) ([]*data.Movie, data.Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+	FROM movies
+	WHERE (title ILIKE '%%' || $1 || '%%' OR $1 = '')
+	AND (genres @> $2 OR $2 = '{}')
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4
+	`, filters.SortColumn(), filters.SortDirection())
+
+	args := []any{likeEscaper.Replace(title), pq.Array(genres), filters.Limit(), filters.Offset()}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, data.Metadata{}, translateError(err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*data.Movie{}
+
+	for rows.Next() {
+		var movie data.Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, data.Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, data.Metadata{}, translateError(err)
+	}
+
+	metadata := data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// Search returns a page of movies whose title matches the full-text query q,
+// written in web search syntax, and whose genres contain every one of genres.
+// The sort column "relevance" orders by the rank of the match.
+func (m MovieModel) Search(ctx context.Context, q string, genres []string, filters data.Filters) ([]*data.MovieSearchResult, data.Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version,
+		ts_rank(search, websearch_to_tsquery('simple', $1)) AS relevance
+	FROM movies
+	WHERE search @@ websearch_to_tsquery('simple', $1)
+	AND (genres @> $2 OR $2 = '{}')
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4
+	`, filters.SortColumn(), filters.SortDirection())
+
+	args := []any{q, pq.Array(genres), filters.Limit(), filters.Offset()}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, data.Metadata{}, translateError(err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	results := []*data.MovieSearchResult{}
+
+	for rows.Next() {
+		result := data.MovieSearchResult{Movie: &data.Movie{}}
+
+		err := rows.Scan(
+			&totalRecords,
+			&result.ID,
+			&result.CreatedAt,
+			&result.Title,
+			&result.Year,
+			&result.Runtime,
+			pq.Array(&result.Genres),
+			&result.Version,
+			&result.Rank,
+		)
+		if err != nil {
+			return nil, data.Metadata{}, err
+		}
+
+		results = append(results, &result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, data.Metadata{}, translateError(err)
+	}
+
+	metadata := data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return results, metadata, nil
+}
+
+func (m MovieModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
@@ -77,12 +317,12 @@ func (m MovieModel) Delete(id int64) error {
 	WHERE id = $1
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -97,7 +337,7 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func (m MovieModel) Update(movie *data.Movie) error {
+func (m MovieModel) Update(ctx context.Context, movie *data.Movie) error {
 	query := `
 		UPDATE movies 
 		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
@@ -114,7 +354,7 @@ func (m MovieModel) Update(movie *data.Movie) error {
 		movie.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
@@ -123,7 +363,7 @@ func (m MovieModel) Update(movie *data.Movie) error {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return err
+			return translateError(err)
 		}
 	}
 
@@ -0,0 +1,32 @@
+//go:build integration
+
+package database_test
+
+import (
+	"context"
+	"pilem/internal/database"
+	"pilem/internal/database/storetest"
+	"testing"
+	"time"
+)
+
+func TestMovieModel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	db, err := database.Open(ctx, database.Config{DSN: database.IntegrationDSN(), MaxOpenConns: 10})
+	if err != nil {
+		t.Fatalf("can't open database. Err: %v", err)
+	}
+	defer db.Close()
+
+	storetest.Run(t, func(t *testing.T) database.MovieStore {
+		// Restart the ids too, the suite expects the ones of an empty store.
+		_, err := db.Exec("TRUNCATE movies RESTART IDENTITY")
+		if err != nil {
+			t.Fatalf("can't empty the movies table. Err: %v", err)
+		}
+
+		return database.MovieModel{DB: db}
+	})
+}
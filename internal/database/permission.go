@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+	"pilem/internal/data"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PermissionStore keeps the permissions granted to the users.
+type PermissionStore interface {
+	GetAllForUser(ctx context.Context, userID int64) (data.Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+}
+
+// PermissionModel is the PermissionStore backed by the permissions and
+// users_permissions tables.
+type PermissionModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// GetAllForUser returns the permission codes granted to userID.
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (data.Permissions, error) {
+	query := `
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+	INNER JOIN users ON users_permissions.user_id = users.id
+	WHERE users.id = $1
+	`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	var permissions data.Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	return permissions, nil
+}
+
+// AddForUser grants the permissions with the given codes to userID.
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+	INSERT INTO users_permissions
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+	ON CONFLICT DO NOTHING
+	`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return translateError(err)
+}
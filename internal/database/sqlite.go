@@ -0,0 +1,424 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"pilem/internal/data"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SQLiteMovieModel is the MovieStore backed by the movies table of a SQLite
+// database, created by the migrations in migrations.SQLiteFS. The genres are
+// stored as a JSON array and created_at as a Unix time.
+//
+// Unlike PostgreSQL, the title filter of List is only case-insensitive for
+// ASCII letters, and Search ranks the movies with the BM25 rank of FTS5
+// rather than ts_rank.
+type SQLiteMovieModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// movieColumns are the columns scanned by scanMovie.
+const movieColumns = `id, created_at, title, year, runtime, genres, version`
+
+// genresFilter is true when the genres of a movie contain every one of the
+// JSON array bound to the parameter, like the @> array operator.
+const genresFilter = `NOT EXISTS (
+		SELECT 1 FROM json_each(?) AS want
+		WHERE want.value NOT IN (SELECT value FROM json_each(movies.genres))
+	)`
+
+// sqliteScanner is implemented by *sql.Row and *sql.Rows.
+type sqliteScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanMovie scans the movieColumns, preceded by dest, into movie.
+func scanMovie(row sqliteScanner, movie *data.Movie, dest ...any) error {
+	var (
+		createdAt int64
+		genres    []byte
+	)
+
+	dest = append(dest, &movie.ID, &createdAt, &movie.Title, &movie.Year, &movie.Runtime, &genres, &movie.Version)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return err
+	}
+
+	movie.CreatedAt = time.Unix(createdAt, 0)
+
+	return json.Unmarshal(genres, &movie.Genres)
+}
+
+func marshalGenres(genres []string) (string, error) {
+	if genres == nil {
+		genres = []string{}
+	}
+
+	b, err := json.Marshal(genres)
+	return string(b), err
+}
+
+func (m SQLiteMovieModel) Insert(ctx context.Context, movie *data.Movie) error {
+	query := `
+	INSERT INTO movies (title, year, runtime, genres)
+	VALUES (?, ?, ?, ?)
+	RETURNING id, created_at, version
+	`
+
+	genres, err := marshalGenres(movie.Genres)
+	if err != nil {
+		return err
+	}
+
+	args := []any{movie.Title, movie.Year, movie.Runtime, genres}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var createdAt int64
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &createdAt, &movie.Version)
+	if err != nil {
+		return translateError(err)
+	}
+
+	movie.CreatedAt = time.Unix(createdAt, 0)
+
+	return nil
+}
+
+func (m SQLiteMovieModel) InsertMany(ctx context.Context, movies []*data.Movie) error {
+	for batch := range slices.Chunk(movies, insertBatchSize) {
+		err := m.insertBatch(ctx, batch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertBatch inserts movies with a single multi-row insert.
+func (m SQLiteMovieModel) insertBatch(ctx context.Context, movies []*data.Movie) error {
+	args := make([]any, 0, 4*len(movies))
+
+	for _, movie := range movies {
+		genres, err := marshalGenres(movie.Genres)
+		if err != nil {
+			return err
+		}
+
+		args = append(args, movie.Title, movie.Year, movie.Runtime, genres)
+	}
+
+	query := `
+	INSERT INTO movies (title, year, runtime, genres)
+	VALUES ` + strings.Repeat("(?, ?, ?, ?), ", len(movies)-1) + `(?, ?, ?, ?)
+	RETURNING id, created_at, version
+	`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return translateError(err)
+	}
+	defer rows.Close()
+
+	inserted := make([]data.Movie, 0, len(movies))
+
+	for rows.Next() {
+		var (
+			movie     data.Movie
+			createdAt int64
+		)
+
+		err := rows.Scan(&movie.ID, &createdAt, &movie.Version)
+		if err != nil {
+			return err
+		}
+
+		movie.CreatedAt = time.Unix(createdAt, 0)
+		inserted = append(inserted, movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return translateError(err)
+	}
+
+	return setInserted(movies, inserted)
+}
+
+func (m SQLiteMovieModel) Get(ctx context.Context, id int64) (*data.Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT ` + movieColumns + `
+	FROM movies
+	WHERE id = ?
+	`
+
+	var movie data.Movie
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := scanMovie(m.DB.QueryRowContext(ctx, query, id), &movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, translateError(err)
+		}
+	}
+
+	return &movie, nil
+}
+
+// List returns a page of movies whose title contains title (case-insensitive)
+// and whose genres contain every one of genres. Empty title or genres match
+// every movie.
+func (m SQLiteMovieModel) List(ctx context.Context, title string, genres []string, filters data.Filters) ([]*data.Movie, data.Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), `+movieColumns+`
+	FROM movies
+	WHERE (title LIKE '%%' || ? || '%%' ESCAPE '\' OR ? = '')
+	AND `+genresFilter+`
+	ORDER BY %s %s, id ASC
+	LIMIT ? OFFSET ?
+	`, filters.SortColumn(), filters.SortDirection())
+
+	wantGenres, err := marshalGenres(genres)
+	if err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	args := []any{likeEscaper.Replace(title), title, wantGenres, filters.Limit(), filters.Offset()}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, data.Metadata{}, translateError(err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*data.Movie{}
+
+	for rows.Next() {
+		var movie data.Movie
+
+		err := scanMovie(rows, &movie, &totalRecords)
+		if err != nil {
+			return nil, data.Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, data.Metadata{}, translateError(err)
+	}
+
+	metadata := data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// Search returns a page of movies whose title matches the full-text query q,
+// written in web search syntax, and whose genres contain every one of genres.
+// The sort column "relevance" orders by the BM25 rank of the words searched
+// for in the title.
+func (m SQLiteMovieModel) Search(ctx context.Context, q string, genres []string, filters data.Filters) ([]*data.MovieSearchResult, data.Metadata, error) {
+	match, rankMatch, matchArgs := parseWebSearch(q).sqliteFilter()
+	if match == "" {
+		// Like an empty tsquery, a query without words matches nothing.
+		return []*data.MovieSearchResult{}, data.Metadata{}, nil
+	}
+
+	wantGenres, err := marshalGenres(genres)
+	if err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	var args []any
+
+	// Only the movies matching the words searched for are ranked, the ones
+	// matching a clause made of excluded words only rank 0.
+	relevance, join := "0", ""
+	if rankMatch != "" {
+		relevance = "coalesce(-search.rank, 0)"
+		join = "LEFT JOIN (SELECT rowid, rank FROM movies_search WHERE movies_search MATCH ?) AS search ON search.rowid = movies.id"
+		args = append(args, rankMatch)
+	}
+
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), %s AS relevance, `+movieColumns+`
+	FROM movies
+	%s
+	WHERE (%s)
+	AND `+genresFilter+`
+	ORDER BY %s %s, id ASC
+	LIMIT ? OFFSET ?
+	`, relevance, join, match, filters.SortColumn(), filters.SortDirection())
+
+	args = append(args, matchArgs...)
+	args = append(args, wantGenres, filters.Limit(), filters.Offset())
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, data.Metadata{}, translateError(err)
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	results := []*data.MovieSearchResult{}
+
+	for rows.Next() {
+		result := data.MovieSearchResult{Movie: &data.Movie{}}
+
+		err := scanMovie(rows, result.Movie, &totalRecords, &result.Rank)
+		if err != nil {
+			return nil, data.Metadata{}, err
+		}
+
+		results = append(results, &result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, data.Metadata{}, translateError(err)
+	}
+
+	metadata := data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return results, metadata, nil
+}
+
+// sqliteFilter returns the condition on the movies matching the query, using
+// the movies_search table, with its arguments, and the FTS5 query of the
+// words searched for, empty when every word is excluded. Every clause is
+// matched on its own since FTS5 can only exclude words from other matches.
+func (query webSearch) sqliteFilter() (match, rankMatch string, args []any) {
+	var clauses, ranked []string
+
+	for _, clause := range query {
+		var include, exclude []string
+
+		for _, term := range clause {
+			// The words are made of letters and digits only, so they need
+			// no escaping inside the quotes.
+			phrase := `"` + strings.Join(term.words, " ") + `"`
+
+			if term.negate {
+				exclude = append(exclude, phrase)
+			} else {
+				include = append(include, phrase)
+			}
+		}
+
+		var conditions []string
+
+		if len(include) > 0 {
+			conditions = append(conditions, "movies.id IN (SELECT rowid FROM movies_search WHERE movies_search MATCH ?)")
+			args = append(args, strings.Join(include, " AND "))
+			ranked = append(ranked, "("+strings.Join(include, " AND ")+")")
+		}
+
+		if len(exclude) > 0 {
+			conditions = append(conditions, "movies.id NOT IN (SELECT rowid FROM movies_search WHERE movies_search MATCH ?)")
+			args = append(args, strings.Join(exclude, " OR "))
+		}
+
+		if len(conditions) > 0 {
+			clauses = append(clauses, "("+strings.Join(conditions, " AND ")+")")
+		}
+	}
+
+	return strings.Join(clauses, " OR "), strings.Join(ranked, " OR "), args
+}
+
+func (m SQLiteMovieModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM movies
+	WHERE id = ?
+	`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return translateError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m SQLiteMovieModel) Update(ctx context.Context, movie *data.Movie) error {
+	query := `
+	UPDATE movies
+	SET title = ?, year = ?, runtime = ?, genres = ?, version = version + 1
+	WHERE id = ? AND version = ?
+	RETURNING version
+	`
+
+	genres, err := marshalGenres(movie.Genres)
+	if err != nil {
+		return err
+	}
+
+	args := []any{
+		movie.Title,
+		movie.Year,
+		movie.Runtime,
+		genres,
+		movie.ID,
+		movie.Version,
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return translateError(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"pilem/internal/data"
+	"pilem/internal/database"
+	"pilem/internal/database/storetest"
+	"pilem/migrations"
+	"slices"
+	"testing"
+	"time"
+)
+
+// openSQLite returns a migrated SQLite database in a temporary file.
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+
+	ctx := context.Background()
+
+	db, err := database.Open(ctx, database.Config{Driver: "sqlite", DSN: "file:" + t.TempDir() + "/pilem.db"})
+	if err != nil {
+		t.Fatalf("can't open database. Err: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = database.NewMigrator(db, "sqlite", migrations.SQLiteFS, discardLogger).Up(ctx)
+	if err != nil {
+		t.Fatalf("can't migrate database. Err: %v", err)
+	}
+
+	return db
+}
+
+func TestSQLiteMovieModel(t *testing.T) {
+	t.Parallel()
+
+	storetest.Run(t, func(t *testing.T) database.MovieStore {
+		return database.SQLiteMovieModel{DB: openSQLite(t)}
+	})
+}
+
+func TestSQLiteMovieModelSearch_FollowChanges(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	m := database.SQLiteMovieModel{DB: openSQLite(t)}
+
+	movies := []*data.Movie{
+		{Title: "Overlord", Year: 2018, Genres: []string{"War"}},
+		{Title: "Lord of War", Year: 2005, Genres: []string{"War"}},
+	}
+
+	for _, movie := range movies {
+		if err := m.Insert(ctx, movie); err != nil {
+			t.Fatalf("can't insert movie. Err: %v", err)
+		}
+	}
+
+	movies[0].Title = "Lord of the Flies"
+	if err := m.Update(ctx, movies[0]); err != nil {
+		t.Fatalf("can't update movie. Err: %v", err)
+	}
+
+	if err := m.Delete(ctx, movies[1].ID); err != nil {
+		t.Fatalf("can't delete movie. Err: %v", err)
+	}
+
+	filters := data.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}}
+
+	tests := []struct {
+		q    string
+		want []int64
+	}{
+		{q: "lord", want: []int64{movies[0].ID}},
+		{q: "overlord", want: []int64{}},
+		{q: "war", want: []int64{}},
+	}
+
+	for _, tt := range tests {
+		results, _, err := m.Search(ctx, tt.q, []string{}, filters)
+		if err != nil {
+			t.Fatalf("can't search %q. Err: %v", tt.q, err)
+		}
+
+		got := []int64{}
+		for _, result := range results {
+			got = append(got, result.ID)
+		}
+
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("search %q: want %v got %v", tt.q, tt.want, got)
+		}
+	}
+}
+
+func TestSQLiteMovieModelSearch_SortByRelevance(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	m := database.SQLiteMovieModel{DB: openSQLite(t)}
+
+	for _, title := range []string{"The Lord of the Rings: The Return of the King", "Lord of War"} {
+		err := m.Insert(ctx, &data.Movie{Title: title, Year: 2003, Genres: []string{"Drama"}})
+		if err != nil {
+			t.Fatalf("can't insert movie. Err: %v", err)
+		}
+	}
+
+	filters := data.Filters{Page: 1, PageSize: 20, Sort: "-relevance", SortSafelist: []string{"-relevance"}}
+
+	results, metadata, err := m.Search(ctx, "lord", []string{}, filters)
+	if err != nil {
+		t.Fatalf("can't search. Err: %v", err)
+	}
+
+	if len(results) != 2 || results[0].ID != 2 || results[0].Rank <= results[1].Rank {
+		t.Errorf("want the shorter title ranked first got %+v", results)
+	}
+
+	if metadata.TotalRecords != 2 {
+		t.Errorf("want 2 records got %d", metadata.TotalRecords)
+	}
+}
+
+func TestSQLiteModels(t *testing.T) {
+	t.Parallel()
+
+	runAccountTests(t, func(t *testing.T) database.Models {
+		return database.NewSQLiteModels(openSQLite(t), database.DefaultQueryTimeout)
+	})
+}
+
+func TestSQLiteModelsWithTx_RollbackOnError(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	m := database.NewSQLiteModels(openSQLite(t), database.DefaultQueryTimeout)
+
+	want := errors.New("boom")
+
+	err := m.WithTx(ctx, nil, func(tx database.Models) error {
+		user := insertUser(t, tx, "alice@example.com")
+
+		err := tx.Permissions.AddForUser(ctx, user.ID, data.PermissionMoviesRead)
+		if err != nil {
+			return err
+		}
+
+		return want
+	})
+	if !errors.Is(err, want) {
+		t.Fatalf("want %v got %v", want, err)
+	}
+
+	_, err = m.Users.GetByEmail(ctx, "alice@example.com")
+	if !errors.Is(err, database.ErrRecordNotFound) {
+		t.Errorf("want the user rolled back got %v", err)
+	}
+}
+
+func TestSQLiteModels_EnforceForeignKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := openSQLite(t)
+	m := database.NewSQLiteModels(db, database.DefaultQueryTimeout)
+
+	user := insertUser(t, m, "alice@example.com")
+
+	_, err := m.Tokens.New(ctx, user.ID, time.Hour, data.ScopeActivation)
+	if err != nil {
+		t.Fatalf("can't insert token. Err: %v", err)
+	}
+
+	err = m.Permissions.AddForUser(ctx, user.ID, data.PermissionMoviesRead)
+	if err != nil {
+		t.Fatalf("can't add permission. Err: %v", err)
+	}
+
+	_, err = m.Tokens.New(ctx, user.ID+1, time.Hour, data.ScopeActivation)
+	if !errors.Is(err, database.ErrForeignKeyViolation) {
+		t.Errorf("want %v for a missing user got %v", database.ErrForeignKeyViolation, err)
+	}
+
+	_, err = db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", user.ID)
+	if err != nil {
+		t.Fatalf("can't delete user. Err: %v", err)
+	}
+
+	for _, table := range []string{"tokens", "users_permissions"} {
+		var n int
+
+		err := db.QueryRowContext(ctx, "SELECT count(*) FROM "+table).Scan(&n)
+		if err != nil {
+			t.Fatalf("can't count %s. Err: %v", table, err)
+		}
+
+		if n != 0 {
+			t.Errorf("want the %s of the user deleted got %d rows", table, n)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"pilem/internal/data"
+	"time"
+)
+
+// SQLiteUserModel is the UserStore backed by the users table of a SQLite
+// database, created by the migrations in migrations.SQLiteFS. created_at is
+// stored as a Unix time.
+//
+// Unlike PostgreSQL, the emails only compare case-insensitively for ASCII
+// letters.
+type SQLiteUserModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// userColumns are the columns scanned by scanUser.
+const userColumns = `users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version`
+
+// scanUser scans the userColumns into a user.
+func scanUser(row sqliteScanner) (*data.User, error) {
+	var (
+		user      data.User
+		createdAt int64
+	)
+
+	err := row.Scan(
+		&user.ID,
+		&createdAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.Hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, translateError(err)
+		}
+	}
+
+	user.CreatedAt = time.Unix(createdAt, 0)
+
+	return &user, nil
+}
+
+func (m SQLiteUserModel) Insert(ctx context.Context, user *data.User) error {
+	query := `
+	INSERT INTO users (name, email, password_hash, activated)
+	VALUES (?, ?, ?, ?)
+	RETURNING id, created_at, version
+	`
+
+	args := []any{user.Name, user.Email, user.Password.Hash, user.Activated}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var createdAt int64
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &createdAt, &user.Version)
+	if err != nil {
+		err = translateError(err)
+		switch {
+		case isDuplicateEmail(err):
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	user.CreatedAt = time.Unix(createdAt, 0)
+
+	return nil
+}
+
+func (m SQLiteUserModel) GetByEmail(ctx context.Context, email string) (*data.User, error) {
+	query := `
+	SELECT ` + userColumns + `
+	FROM users
+	WHERE email = ?
+	`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return scanUser(m.DB.QueryRowContext(ctx, query, email))
+}
+
+func (m SQLiteUserModel) Update(ctx context.Context, user *data.User) error {
+	query := `
+	UPDATE users
+	SET name = ?, email = ?, password_hash = ?, activated = ?, version = version + 1
+	WHERE id = ? AND version = ?
+	RETURNING version
+	`
+
+	args := []any{
+		user.Name,
+		user.Email,
+		user.Password.Hash,
+		user.Activated,
+		user.ID,
+		user.Version,
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		err = translateError(err)
+		switch {
+		case isDuplicateEmail(err):
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetForToken returns the user owning the unexpired token of scope whose
+// plaintext is tokenPlaintext.
+func (m SQLiteUserModel) GetForToken(ctx context.Context, scope, tokenPlaintext string) (*data.User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	SELECT ` + userColumns + `
+	FROM users
+	INNER JOIN tokens
+	ON users.id = tokens.user_id
+	WHERE tokens.hash = ?
+	AND tokens.scope = ?
+	AND tokens.expiry > ?
+	`
+
+	args := []any{tokenHash[:], scope, time.Now().Unix()}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return scanUser(m.DB.QueryRowContext(ctx, query, args...))
+}
+
+// SQLiteTokenModel is the TokenStore backed by the tokens table of a SQLite
+// database, with the expiry stored as a Unix time.
+type SQLiteTokenModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// New generates a token for userID and stores it.
+func (m SQLiteTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*data.Token, error) {
+	token, err := data.GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m SQLiteTokenModel) Insert(ctx context.Context, token *data.Token) error {
+	query := `
+	INSERT INTO tokens (hash, user_id, expiry, scope)
+	VALUES (?, ?, ?, ?)
+	`
+
+	args := []any{token.Hash, token.UserID, token.Expiry.Unix(), token.Scope}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return translateError(err)
+}
+
+// DeleteAllForUser deletes every token of scope that belongs to userID.
+func (m SQLiteTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	query := `
+	DELETE FROM tokens
+	WHERE scope = ? AND user_id = ?
+	`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, userID)
+	return translateError(err)
+}
+
+// SQLitePermissionModel is the PermissionStore backed by the permissions and
+// users_permissions tables of a SQLite database.
+type SQLitePermissionModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// GetAllForUser returns the permission codes granted to userID.
+func (m SQLitePermissionModel) GetAllForUser(ctx context.Context, userID int64) (data.Permissions, error) {
+	query := `
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+	INNER JOIN users ON users_permissions.user_id = users.id
+	WHERE users.id = ?
+	`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	var permissions data.Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	return permissions, nil
+}
+
+// AddForUser grants the permissions with the given codes to userID.
+func (m SQLitePermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	// The codes are bound as a JSON array, SQLite having no array type.
+	query := `
+	INSERT INTO users_permissions
+	SELECT ?, permissions.id FROM permissions WHERE permissions.code IN (SELECT value FROM json_each(?))
+	ON CONFLICT DO NOTHING
+	`
+
+	wantCodes, err := json.Marshal(codes)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, userID, string(wantCodes))
+	return translateError(err)
+}
@@ -0,0 +1,457 @@
+// Package storetest provides a conformance test suite for the
+// implementations of database.MovieStore, so every backend behaves like the
+// PostgreSQL one.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"pilem/internal/data"
+	"pilem/internal/database"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Factory returns an empty store. It is called once per test, which can
+// clean up with t.Cleanup.
+type Factory func(t *testing.T) database.MovieStore
+
+// Run runs the conformance suite against the stores returned by newStore.
+// The tests run one after the other, so the stores may share a database.
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s database.MovieStore)
+	}{
+		{"InsertAndGet", testInsertAndGet},
+		{"GenresRoundTrip", testGenresRoundTrip},
+		{"InsertCheckViolation", testInsertCheckViolation},
+		{"InsertMany", testInsertMany},
+		{"InsertManyCheckViolation", testInsertManyCheckViolation},
+		{"NotFound", testNotFound},
+		{"Update", testUpdate},
+		{"UpdateConflict", testUpdateConflict},
+		{"ConcurrentUpdates", testConcurrentUpdates},
+		{"Delete", testDelete},
+		{"ListFilters", testListFilters},
+		{"ListSortAndPaginate", testListSortAndPaginate},
+		{"Search", testSearch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+func newMovie(title string, year int32, genres ...string) *data.Movie {
+	return &data.Movie{Title: title, Year: year, Runtime: 100, Genres: genres}
+}
+
+func mustInsert(t *testing.T, s database.MovieStore, movies ...*data.Movie) {
+	t.Helper()
+
+	for _, movie := range movies {
+		if err := s.Insert(context.Background(), movie); err != nil {
+			t.Fatalf("can't insert movie %q. Err: %v", movie.Title, err)
+		}
+	}
+}
+
+func mustGet(t *testing.T, s database.MovieStore, id int64) *data.Movie {
+	t.Helper()
+
+	movie, err := s.Get(context.Background(), id)
+	if err != nil {
+		t.Fatalf("can't get movie %d. Err: %v", id, err)
+	}
+
+	return movie
+}
+
+func testInsertAndGet(t *testing.T, s database.MovieStore) {
+	first := newMovie("Overlord", 2018, "Action", "War")
+	second := newMovie("Overlord II", 2019, "Action")
+	mustInsert(t, s, first, second)
+
+	if first.ID < 1 || second.ID <= first.ID {
+		t.Errorf("want increasing ids got %d then %d", first.ID, second.ID)
+	}
+	if first.Version != 1 {
+		t.Errorf("want version 1 got %d", first.Version)
+	}
+	if time.Since(first.CreatedAt).Abs() > time.Minute {
+		t.Errorf("want created_at around now got %v", first.CreatedAt)
+	}
+
+	got := mustGet(t, s, first.ID)
+
+	if diff := cmp.Diff(first, got); diff != "" {
+		t.Errorf("movie mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func testGenresRoundTrip(t *testing.T, s database.MovieStore) {
+	genres := []string{"Sci-Fi", `Film "Noir"`, "Drama, Romance", "{Braces}", "Ação"}
+
+	movie := newMovie("Overlord", 2018, genres...)
+	mustInsert(t, s, movie)
+
+	got := mustGet(t, s, movie.ID)
+
+	if diff := cmp.Diff(genres, got.Genres); diff != "" {
+		t.Errorf("genres mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func testInsertCheckViolation(t *testing.T, s database.MovieStore) {
+	tests := []struct {
+		movie      *data.Movie
+		constraint string
+	}{
+		{movie: newMovie("Too old", 1700, "Drama"), constraint: "movies_year_check"},
+		{movie: &data.Movie{Title: "Negative", Year: 2000, Runtime: -1, Genres: []string{"Drama"}}, constraint: "movies_runtime_check"},
+		{movie: newMovie("Too many genres", 2000, "a", "b", "c", "d", "e", "f"), constraint: "genres_length_check"},
+	}
+
+	for _, tt := range tests {
+		err := s.Insert(context.Background(), tt.movie)
+
+		var queryErr *database.QueryError
+		if !errors.Is(err, database.ErrCheckViolation) || !errors.As(err, &queryErr) || queryErr.Constraint != tt.constraint {
+			t.Errorf("insert %q: want check violation on %s got %v", tt.movie.Title, tt.constraint, err)
+		}
+	}
+}
+
+func testInsertMany(t *testing.T, s database.MovieStore) {
+	// Enough movies for more than one statement.
+	movies := make([]*data.Movie, 1500)
+	for i := range movies {
+		movies[i] = newMovie(fmt.Sprintf("Movie %d", i), 2000, "Drama")
+	}
+
+	err := s.InsertMany(context.Background(), movies)
+	if err != nil {
+		t.Fatalf("can't insert movies. Err: %v", err)
+	}
+
+	for i, movie := range movies {
+		if i > 0 && movie.ID <= movies[i-1].ID {
+			t.Fatalf("want increasing ids got %d then %d", movies[i-1].ID, movie.ID)
+		}
+		if movie.Version != 1 {
+			t.Fatalf("want version 1 got %d", movie.Version)
+		}
+	}
+
+	for _, movie := range []*data.Movie{movies[0], movies[1000], movies[1499]} {
+		got := mustGet(t, s, movie.ID)
+
+		if diff := cmp.Diff(movie, got); diff != "" {
+			t.Errorf("movie mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func testInsertManyCheckViolation(t *testing.T, s database.MovieStore) {
+	movies := []*data.Movie{
+		newMovie("Overlord", 2018, "Action"),
+		newMovie("Too old", 1700, "Drama"),
+	}
+
+	err := s.InsertMany(context.Background(), movies)
+	if !errors.Is(err, database.ErrCheckViolation) {
+		t.Errorf("want ErrCheckViolation got %v", err)
+	}
+
+	_, metadata, err := s.List(context.Background(), "", nil, data.Filters{Page: 1, PageSize: 10, Sort: "id", SortSafelist: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.TotalRecords != 0 {
+		t.Errorf("want no movie inserted got %d", metadata.TotalRecords)
+	}
+}
+
+func testNotFound(t *testing.T, s database.MovieStore) {
+	mustInsert(t, s, newMovie("Overlord", 2018, "Action"))
+
+	for _, id := range []int64{-1, 0, 1000} {
+		if _, err := s.Get(context.Background(), id); !errors.Is(err, database.ErrRecordNotFound) {
+			t.Errorf("get %d: want ErrRecordNotFound got %v", id, err)
+		}
+
+		if err := s.Delete(context.Background(), id); !errors.Is(err, database.ErrRecordNotFound) {
+			t.Errorf("delete %d: want ErrRecordNotFound got %v", id, err)
+		}
+	}
+}
+
+func testUpdate(t *testing.T, s database.MovieStore) {
+	movie := newMovie("Overlord", 2018, "Action")
+	mustInsert(t, s, movie)
+
+	movie.Title = "Overlord II"
+	movie.Year = 2019
+	movie.Runtime = 120
+	movie.Genres = []string{"Action", "Horror"}
+
+	err := s.Update(context.Background(), movie)
+	if err != nil {
+		t.Fatalf("can't update movie. Err: %v", err)
+	}
+	if movie.Version != 2 {
+		t.Errorf("want version 2 got %d", movie.Version)
+	}
+
+	got := mustGet(t, s, movie.ID)
+
+	if diff := cmp.Diff(movie, got); diff != "" {
+		t.Errorf("movie mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func testUpdateConflict(t *testing.T, s database.MovieStore) {
+	movie := newMovie("Overlord", 2018, "Action")
+	mustInsert(t, s, movie)
+
+	stale := mustGet(t, s, movie.ID)
+
+	movie.Title = "Overlord II"
+	if err := s.Update(context.Background(), movie); err != nil {
+		t.Fatalf("can't update movie. Err: %v", err)
+	}
+
+	stale.Title = "Overlord III"
+	if err := s.Update(context.Background(), stale); !errors.Is(err, database.ErrEditConflict) {
+		t.Errorf("stale version: want ErrEditConflict got %v", err)
+	}
+
+	missing := newMovie("Missing", 2018, "Action")
+	missing.ID, missing.Version = 1000, 1
+	if err := s.Update(context.Background(), missing); !errors.Is(err, database.ErrEditConflict) {
+		t.Errorf("missing movie: want ErrEditConflict got %v", err)
+	}
+
+	if got := mustGet(t, s, movie.ID); got.Title != "Overlord II" {
+		t.Errorf("want title %q got %q", "Overlord II", got.Title)
+	}
+}
+
+func testConcurrentUpdates(t *testing.T, s database.MovieStore) {
+	movie := newMovie("Overlord", 2018, "Action")
+	mustInsert(t, s, movie)
+
+	const writers = 8
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		updated   int
+		conflicts int
+	)
+
+	for i := range writers {
+		update := *movie
+		update.Runtime = data.Runtime(100 + i)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := s.Update(context.Background(), &update)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch {
+			case err == nil:
+				updated++
+			case errors.Is(err, database.ErrEditConflict):
+				conflicts++
+			default:
+				t.Errorf("want nil or ErrEditConflict got %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if updated != 1 || conflicts != writers-1 {
+		t.Errorf("want 1 update and %d conflicts got %d and %d", writers-1, updated, conflicts)
+	}
+
+	if got := mustGet(t, s, movie.ID); got.Version != 2 {
+		t.Errorf("want version 2 got %d", got.Version)
+	}
+}
+
+func testDelete(t *testing.T, s database.MovieStore) {
+	movie := newMovie("Overlord", 2018, "Action")
+	mustInsert(t, s, movie)
+
+	if err := s.Delete(context.Background(), movie.ID); err != nil {
+		t.Fatalf("can't delete movie. Err: %v", err)
+	}
+
+	if _, err := s.Get(context.Background(), movie.ID); !errors.Is(err, database.ErrRecordNotFound) {
+		t.Errorf("get deleted movie: want ErrRecordNotFound got %v", err)
+	}
+
+	if err := s.Delete(context.Background(), movie.ID); !errors.Is(err, database.ErrRecordNotFound) {
+		t.Errorf("delete twice: want ErrRecordNotFound got %v", err)
+	}
+}
+
+// listIDs returns the ids of the movies.
+func listIDs(movies []*data.Movie) []int64 {
+	ids := []int64{}
+	for _, movie := range movies {
+		ids = append(ids, movie.ID)
+	}
+
+	return ids
+}
+
+func testListFilters(t *testing.T, s database.MovieStore) {
+	mustInsert(t, s,
+		newMovie("Overlord", 2018, "Action", "War"),
+		newMovie("The Lord of the Rings", 2001, "Adventure", "Fantasy"),
+		newMovie("100% Wolf", 2020, "Animation"),
+		newMovie("Lord_of_War", 2005, "Crime", "War"),
+	)
+
+	filters := data.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}}
+
+	tests := []struct {
+		name   string
+		title  string
+		genres []string
+		want   []int64
+	}{
+		{name: "no filter", genres: []string{}, want: []int64{1, 2, 3, 4}},
+		{name: "title is case-insensitive", title: "LORD", genres: []string{}, want: []int64{1, 2, 4}},
+		{name: "percent is literal", title: "0%", genres: []string{}, want: []int64{3}},
+		{name: "underscore is literal", title: "d_o", genres: []string{}, want: []int64{4}},
+		{name: "every genre must match", genres: []string{"War", "Crime"}, want: []int64{4}},
+		{name: "title and genre", title: "lord", genres: []string{"War"}, want: []int64{1, 4}},
+		{name: "no match", title: "matrix", genres: []string{}, want: []int64{}},
+	}
+
+	for _, tt := range tests {
+		movies, _, err := s.List(context.Background(), tt.title, tt.genres, filters)
+		if err != nil {
+			t.Fatalf("%s: can't list movies. Err: %v", tt.name, err)
+		}
+
+		if diff := cmp.Diff(tt.want, listIDs(movies)); diff != "" {
+			t.Errorf("%s: ids mismatch (-want +got):\n%s", tt.name, diff)
+		}
+	}
+}
+
+func testListSortAndPaginate(t *testing.T, s database.MovieStore) {
+	mustInsert(t, s,
+		newMovie("Alpha", 2005, "Drama"),
+		newMovie("Bravo", 2001, "Drama"),
+		newMovie("Charlie", 2005, "Drama"),
+		newMovie("Delta", 2010, "Drama"),
+		newMovie("Echo", 2001, "Drama"),
+	)
+
+	safelist := []string{"id", "title", "year", "-id", "-title", "-year"}
+
+	tests := []struct {
+		name         string
+		filters      data.Filters
+		want         []int64
+		wantMetadata data.Metadata
+	}{
+		{
+			name:         "ties are ordered by ascending id",
+			filters:      data.Filters{Page: 1, PageSize: 5, Sort: "-year", SortSafelist: safelist},
+			want:         []int64{4, 1, 3, 2, 5},
+			wantMetadata: data.Metadata{CurrentPage: 1, PageSize: 5, FirstPage: 1, LastPage: 1, TotalRecords: 5},
+		},
+		{
+			name:         "second page",
+			filters:      data.Filters{Page: 2, PageSize: 2, Sort: "title", SortSafelist: safelist},
+			want:         []int64{3, 4},
+			wantMetadata: data.Metadata{CurrentPage: 2, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5},
+		},
+		{
+			name:         "last page",
+			filters:      data.Filters{Page: 3, PageSize: 2, Sort: "-id", SortSafelist: safelist},
+			want:         []int64{1},
+			wantMetadata: data.Metadata{CurrentPage: 3, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5},
+		},
+		{
+			name:    "page past the end has no metadata",
+			filters: data.Filters{Page: 4, PageSize: 2, Sort: "id", SortSafelist: safelist},
+			want:    []int64{},
+		},
+	}
+
+	for _, tt := range tests {
+		movies, metadata, err := s.List(context.Background(), "", []string{}, tt.filters)
+		if err != nil {
+			t.Fatalf("%s: can't list movies. Err: %v", tt.name, err)
+		}
+
+		if diff := cmp.Diff(tt.want, listIDs(movies)); diff != "" {
+			t.Errorf("%s: ids mismatch (-want +got):\n%s", tt.name, diff)
+		}
+
+		if diff := cmp.Diff(tt.wantMetadata, metadata); diff != "" {
+			t.Errorf("%s: metadata mismatch (-want +got):\n%s", tt.name, diff)
+		}
+	}
+}
+
+func testSearch(t *testing.T, s database.MovieStore) {
+	mustInsert(t, s,
+		newMovie("The Lord of the Rings", 2001, "Adventure", "Fantasy"),
+		newMovie("Overlord", 2018, "Action", "War"),
+		newMovie("Lord of War", 2005, "Crime", "War"),
+		newMovie("War of the Worlds", 2005, "Action", "Sci-Fi"),
+	)
+
+	filters := data.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}}
+
+	tests := []struct {
+		q      string
+		genres []string
+		want   []int64
+	}{
+		{q: "lord", genres: []string{}, want: []int64{1, 3}},
+		{q: "LORD war", genres: []string{}, want: []int64{3}},
+		{q: "war -lord", genres: []string{}, want: []int64{4}},
+		{q: "-war", genres: []string{}, want: []int64{1, 2}},
+		{q: `"of the"`, genres: []string{}, want: []int64{1, 4}},
+		{q: "rings or worlds", genres: []string{}, want: []int64{1, 4}},
+		{q: "war", genres: []string{"Crime"}, want: []int64{3}},
+	}
+
+	for _, tt := range tests {
+		results, _, err := s.Search(context.Background(), tt.q, tt.genres, filters)
+		if err != nil {
+			t.Fatalf("can't search %q. Err: %v", tt.q, err)
+		}
+
+		got := []int64{}
+		for _, result := range results {
+			got = append(got, result.ID)
+		}
+
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("search %q mismatch (-want +got):\n%s", tt.q, diff)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"pilem/internal/data"
+	"time"
+)
+
+// TokenStore keeps the hashes of the tokens given to the users.
+type TokenStore interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*data.Token, error)
+	Insert(ctx context.Context, token *data.Token) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+}
+
+// TokenModel is the TokenStore backed by the tokens table.
+type TokenModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// New generates a token for userID and stores it.
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*data.Token, error) {
+	token, err := data.GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m TokenModel) Insert(ctx context.Context, token *data.Token) error {
+	query := `
+	INSERT INTO tokens (hash, user_id, expiry, scope)
+	VALUES ($1, $2, $3, $4)
+	`
+
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return translateError(err)
+}
+
+// DeleteAllForUser deletes every token of scope that belongs to userID.
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	query := `
+	DELETE FROM tokens
+	WHERE scope = $1 AND user_id = $2
+	`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, userID)
+	return translateError(err)
+}
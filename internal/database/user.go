@@ -0,0 +1,170 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"pilem/internal/data"
+	"time"
+)
+
+// UserStore keeps the user accounts. The emails are unique regardless of
+// case.
+type UserStore interface {
+	Insert(ctx context.Context, user *data.User) error
+	GetByEmail(ctx context.Context, email string) (*data.User, error)
+	Update(ctx context.Context, user *data.User) error
+	GetForToken(ctx context.Context, scope, tokenPlaintext string) (*data.User, error)
+}
+
+// UserModel is the UserStore backed by the users table.
+type UserModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// isDuplicateEmail reports whether err is the violation of the unique
+// constraint on users.email.
+func isDuplicateEmail(err error) bool {
+	var queryErr *QueryError
+	return errors.Is(err, ErrUniqueViolation) && errors.As(err, &queryErr) && queryErr.Constraint == "users_email_key"
+}
+
+func (m UserModel) Insert(ctx context.Context, user *data.User) error {
+	query := `
+	INSERT INTO users (name, email, password_hash, activated)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, version
+	`
+
+	args := []any{user.Name, user.Email, user.Password.Hash, user.Activated}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		err = translateError(err)
+		switch {
+		case isDuplicateEmail(err):
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*data.User, error) {
+	query := `
+	SELECT id, created_at, name, email, password_hash, activated, version
+	FROM users
+	WHERE email = $1
+	`
+
+	var user data.User
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.Hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, translateError(err)
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserModel) Update(ctx context.Context, user *data.User) error {
+	query := `
+	UPDATE users
+	SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
+	WHERE id = $5 AND version = $6
+	RETURNING version
+	`
+
+	args := []any{
+		user.Name,
+		user.Email,
+		user.Password.Hash,
+		user.Activated,
+		user.ID,
+		user.Version,
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		err = translateError(err)
+		switch {
+		case isDuplicateEmail(err):
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetForToken returns the user owning the unexpired token of scope whose
+// plaintext is tokenPlaintext.
+func (m UserModel) GetForToken(ctx context.Context, scope, tokenPlaintext string) (*data.User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	FROM users
+	INNER JOIN tokens
+	ON users.id = tokens.user_id
+	WHERE tokens.hash = $1
+	AND tokens.scope = $2
+	AND tokens.expiry > $3
+	`
+
+	args := []any{tokenHash[:], scope, time.Now()}
+
+	var user data.User
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.Hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, translateError(err)
+		}
+	}
+
+	return &user, nil
+}
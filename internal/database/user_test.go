@@ -0,0 +1,92 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"pilem/helper"
+	"pilem/internal/data"
+	"pilem/internal/database"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestUserInsert_GetIDCreatedAtVersion(t *testing.T) {
+	t.Parallel()
+
+	user := data.User{
+		Name:     "alice",
+		Email:    "alice@example.com",
+		Password: data.Password{Hash: []byte("hash")},
+	}
+
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		rows := sqlmock.NewRows([]string{"id", "created_at", "version"}).AddRow(7, time.Now(), 1)
+		mock.ExpectQuery(`INSERT INTO users (.+) RETURNING id, created_at, version`).
+			WithArgs(user.Name, user.Email, user.Password.Hash, false).
+			WillReturnRows(rows)
+	})
+
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+	err := m.Users.Insert(context.Background(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal("query not as expected", err)
+	}
+
+	if user.ID != 7 || user.Version != 1 {
+		t.Errorf("want id 7 and version 1 got id %d and version %d", user.ID, user.Version)
+	}
+}
+
+func TestUserInsert_ReturnErrDuplicateEmail(t *testing.T) {
+	t.Parallel()
+
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("INSERT INTO users").
+			WillReturnError(&pq.Error{Code: "23505", Constraint: "users_email_key"})
+	})
+
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+	err := m.Users.Insert(context.Background(), &data.User{Email: "alice@example.com"})
+
+	if !errors.Is(err, database.ErrDuplicateEmail) {
+		t.Errorf("want ErrDuplicateEmail got %v", err)
+	}
+}
+
+func TestUserGetByEmail_ReturnErrRecordNotFound(t *testing.T) {
+	t.Parallel()
+
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("FROM users").WithArgs("nobody@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	})
+
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+	_, err := m.Users.GetByEmail(context.Background(), "nobody@example.com")
+
+	if !errors.Is(err, database.ErrRecordNotFound) {
+		t.Errorf("want ErrRecordNotFound got %v", err)
+	}
+}
+
+func TestUserUpdate_ReturnErrEditConflict(t *testing.T) {
+	t.Parallel()
+
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("UPDATE users").WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	})
+
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+	err := m.Users.Update(context.Background(), &data.User{ID: 1, Version: 1})
+
+	if !errors.Is(err, database.ErrEditConflict) {
+		t.Errorf("want ErrEditConflict got %v", err)
+	}
+}
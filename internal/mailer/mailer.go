@@ -0,0 +1,197 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"time"
+
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer sends the email described by templateFile to recipient. The
+// template must define the "subject", "plainBody" and "htmlBody" templates,
+// which are executed with data. ctx cuts the retries short.
+type Mailer interface {
+	Send(ctx context.Context, recipient, templateFile string, data any) error
+}
+
+// maxSendAttempts is how many times SMTPMailer tries to send an email.
+const maxSendAttempts = 3
+
+// sendRetryDelay is the delay between two attempts of SMTPMailer.
+const sendRetryDelay = 500 * time.Millisecond
+
+// Message is a rendered email.
+type Message struct {
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// Render executes the templates of templateFile with data.
+func Render(templateFile string, data any) (*Message, error) {
+	textTmpl, err := texttemplate.New("").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	// The HTML body goes through html/template so data is escaped.
+	htmlTmpl, err := htmltemplate.New("").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
+
+// SMTPMailer sends emails through an SMTP server.
+type SMTPMailer struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+}
+
+// NewSMTP returns a Mailer sending emails from sender through the SMTP server
+// at host:port. It authenticates with PLAIN auth unless username is empty.
+func NewSMTP(host string, port int, username, password, sender string) *SMTPMailer {
+	m := &SMTPMailer{
+		addr:   host + ":" + strconv.Itoa(port),
+		sender: sender,
+	}
+
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, recipient, templateFile string, data any) error {
+	msg, err := Render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	body, err := msg.bytes(m.sender, recipient)
+	if err != nil {
+		return err
+	}
+
+	// Retry a few times, since a single failure is often a transient network
+	// problem.
+	for attempt := 1; ; attempt++ {
+		err = smtp.SendMail(m.addr, m.auth, m.sender, []string{recipient}, body)
+		if err == nil || attempt == maxSendAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(sendRetryDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// bytes encodes msg as a multipart/alternative MIME message.
+func (msg *Message) bytes(sender, recipient string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+
+	fmt.Fprintf(buf, "From: %s\r\n", sender)
+	fmt.Fprintf(buf, "To: %s\r\n", recipient)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", msg.PlainBody},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	}
+
+	for _, part := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = io.WriteString(w, part.body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// LogMailer writes emails to an io.Writer instead of sending them, which is
+// handy in development.
+type LogMailer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewLogMailer returns a Mailer writing every email to w, which can be a log
+// file or os.Stdout.
+func NewLogMailer(w io.Writer) *LogMailer {
+	return &LogMailer{w: w}
+}
+
+func (m *LogMailer) Send(ctx context.Context, recipient, templateFile string, data any) error {
+	msg, err := Render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err = fmt.Fprintf(m.w, "To: %s\nSubject: %s\n\n%s\n\n", recipient, msg.Subject, msg.PlainBody)
+	return err
+}
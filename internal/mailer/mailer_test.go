@@ -0,0 +1,199 @@
+package mailer_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/textproto"
+	"pilem/internal/mailer"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer accepts a single SMTP session on a local port and sends the
+// envelope recipients and the DATA it received on the returned channel.
+func fakeSMTPServer(t *testing.T) (host string, port int, received <-chan string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't listen for fake SMTP server. Err:%v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	ch := make(chan string, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tp := textproto.NewConn(conn)
+		var session strings.Builder
+
+		tp.PrintfLine("220 localhost fake SMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+
+			verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch verb {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 localhost")
+			case "MAIL":
+				tp.PrintfLine("250 OK")
+			case "RCPT":
+				session.WriteString(line + "\n")
+				tp.PrintfLine("250 OK")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				session.Write(data)
+				tp.PrintfLine("250 OK")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				ch <- session.String()
+				return
+			default:
+				tp.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, ch
+}
+
+func TestSMTPMailerSend_DeliverRenderedTemplate(t *testing.T) {
+	t.Parallel()
+
+	host, port, received := fakeSMTPServer(t)
+
+	m := mailer.NewSMTP(host, port, "", "", "Pilem <no-reply@pilem.test>")
+
+	data := map[string]any{"userID": 42, "activationToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"}
+	err := m.Send(context.Background(), "alice@example.com", "user_welcome.tmpl", data)
+	if err != nil {
+		t.Fatalf("can't send email. Err:%v", err)
+	}
+
+	got := <-received
+
+	for _, want := range []string{
+		"RCPT TO:<alice@example.com>",
+		"Subject: Welcome to Pilem!",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Type: text/html; charset=utf-8",
+		"your user ID number is 42",
+		`{"token": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"}`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want email to contain %q, got:\n%s", want, got)
+		}
+	}
+}
+
+// closedSMTPAddr returns the address of a port nothing listens on, so every
+// attempt to send an email fails right away.
+func closedSMTPAddr(t *testing.T) (host string, port int) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestSMTPMailerSend_NoDelayAfterLastAttempt(t *testing.T) {
+	t.Parallel()
+
+	host, port := closedSMTPAddr(t)
+	m := mailer.NewSMTP(host, port, "", "", "Pilem <no-reply@pilem.test>")
+
+	start := time.Now()
+	err := m.Send(context.Background(), "alice@example.com", "user_welcome.tmpl", map[string]any{})
+	if err == nil {
+		t.Fatal("want error sending to a closed port")
+	}
+
+	// Three attempts are two delays of 500ms apart.
+	if elapsed := time.Since(start); elapsed >= 1400*time.Millisecond {
+		t.Errorf("want about 1s of retries got %s", elapsed)
+	}
+}
+
+func TestSMTPMailerSend_StopRetryingWhenContextDone(t *testing.T) {
+	t.Parallel()
+
+	host, port := closedSMTPAddr(t)
+	m := mailer.NewSMTP(host, port, "", "", "Pilem <no-reply@pilem.test>")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	err := m.Send(ctx, "alice@example.com", "user_welcome.tmpl", map[string]any{})
+	if err == nil {
+		t.Fatal("want error sending to a closed port")
+	}
+
+	if elapsed := time.Since(start); elapsed >= 400*time.Millisecond {
+		t.Errorf("want no retry after the context is done got %s", elapsed)
+	}
+}
+
+func TestLogMailerSend_WriteRenderedTemplate(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	m := mailer.NewLogMailer(&buf)
+
+	data := map[string]any{"passwordResetToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"}
+	err := m.Send(context.Background(), "alice@example.com", "token_password_reset.tmpl", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := buf.String()
+
+	if !strings.HasPrefix(got, "To: alice@example.com\nSubject: Reset your Pilem password\n") {
+		t.Errorf("want email to start with recipient and subject, got:\n%s", got)
+	}
+
+	if !strings.Contains(got, "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU") {
+		t.Errorf("want email to contain the token, got:\n%s", got)
+	}
+}
+
+func TestRender_EscapeHTMLBody(t *testing.T) {
+	t.Parallel()
+
+	msg, err := mailer.Render("user_welcome.tmpl", map[string]any{"userID": 1, "activationToken": "<script>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(msg.HTMLBody, "<script>") {
+		t.Error("want the token to be escaped in the HTML body")
+	}
+
+	if !strings.Contains(msg.PlainBody, "<script>") {
+		t.Error("want the token to stay as is in the plain body")
+	}
+
+	if msg.Subject != "Welcome to Pilem!" {
+		t.Errorf("want subject %q got %q", "Welcome to Pilem!", msg.Subject)
+	}
+}
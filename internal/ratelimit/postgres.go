@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+)
+
+// Postgres limits requests with a sliding window counter stored in the
+// rate_limits table, so every instance sharing the database shares the
+// limits.
+//
+// Requests are counted in fixed windows. The number of requests in the
+// sliding window ending now is estimated as the count of the current window
+// plus the count of the previous window weighted by how much of it still
+// overlaps the sliding window.
+type Postgres struct {
+	db      *sql.DB
+	limit   int
+	window  time.Duration
+	timeout time.Duration
+}
+
+// NewPostgres returns a limiter allowing each key burst requests per window
+// of burst/rps seconds, which averages to rps requests per second. Both must
+// be greater than 0. Each query is bounded by timeout, on top of the caller
+// context.
+func NewPostgres(db *sql.DB, rps float64, burst int, timeout time.Duration) *Postgres {
+	return &Postgres{
+		db:      db,
+		limit:   burst,
+		window:  time.Duration(float64(burst) / rps * float64(time.Second)),
+		timeout: timeout,
+	}
+}
+
+func (p *Postgres) Allow(ctx context.Context, key string) (Result, error) {
+	now := time.Now()
+	windowStart := now.Truncate(p.window)
+	elapsed := now.Sub(windowStart)
+	prevWeight := 1 - float64(elapsed)/float64(p.window)
+
+	// The upsert only counts the request when the estimate stays within the
+	// limit. ON CONFLICT locks the row, so concurrent requests of a key are
+	// counted one after the other even across instances.
+	query := `
+	WITH prev AS (
+		SELECT COALESCE(SUM(count), 0) AS count
+		FROM rate_limits
+		WHERE key = $1 AND window_start = $3
+	), upsert AS (
+		INSERT INTO rate_limits AS rl (key, window_start, count)
+		SELECT $1, $2, 1 FROM prev WHERE prev.count * $4::float8 + 1 <= $5
+		ON CONFLICT (key, window_start) DO UPDATE
+		SET count = rl.count + 1
+		WHERE rl.count + 1 + (SELECT count FROM prev) * $4::float8 <= $5
+		RETURNING rl.count
+	)
+	SELECT
+		(SELECT count FROM upsert),
+		(SELECT count FROM prev),
+		(SELECT count FROM rate_limits WHERE key = $1 AND window_start = $2)
+	`
+
+	args := []any{key, windowStart, windowStart.Add(-p.window), prevWeight, p.limit}
+
+	var (
+		counted sql.NullInt64
+		prev    int64
+		current sql.NullInt64
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	err := p.db.QueryRowContext(ctx, query, args...).Scan(&counted, &prev, &current)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{Limit: p.limit, Allowed: counted.Valid}
+
+	cur := current.Int64
+	if counted.Valid {
+		// The last subquery sees the table as it was before the upsert.
+		cur = counted.Int64
+	}
+
+	estimate := float64(prev)*prevWeight + float64(cur)
+	result.Remaining = max(int(math.Floor(float64(p.limit)-estimate)), 0)
+
+	switch {
+	case cur > 0:
+		result.ResetAfter = 2*p.window - elapsed
+	case prev > 0:
+		result.ResetAfter = p.window - elapsed
+	}
+
+	if !result.Allowed {
+		result.RetryAfter = p.retryAfter(float64(prev), float64(cur), elapsed)
+	}
+
+	return result, nil
+}
+
+// retryAfter returns how long until the estimate leaves room for one more
+// request, given the previous and current window counts.
+func (p *Postgres) retryAfter(prev, cur float64, elapsed time.Duration) time.Duration {
+	room := float64(p.limit - 1)
+
+	// The previous window alone keeps the estimate over the limit, wait for
+	// its weight to decrease enough.
+	if cur <= room {
+		at := time.Duration(float64(p.window) * (1 - (room-cur)/prev))
+		return max(at-elapsed, time.Millisecond)
+	}
+
+	// Otherwise wait for the next window, where the current count becomes the
+	// weighted previous one.
+	at := time.Duration(float64(p.window) * (1 - room/cur))
+	return p.window - elapsed + at
+}
+
+// Sweep deletes the windows that ended more than idleTimeout ago and can't
+// count toward the estimate anymore.
+func (p *Postgres) Sweep(ctx context.Context, idleTimeout time.Duration) error {
+	query := `
+	DELETE FROM rate_limits
+	WHERE window_start < $1
+	`
+
+	cutoff := time.Now().Add(-max(idleTimeout, 2*p.window))
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	_, err := p.db.ExecContext(ctx, query, cutoff)
+	return err
+}
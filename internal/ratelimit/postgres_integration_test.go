@@ -0,0 +1,75 @@
+//go:build integration
+
+package ratelimit_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"pilem/internal/ratelimit"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// startPostgres starts a Postgres container with the rate_limits table and
+// returns a connection to it.
+func startPostgres(t *testing.T) *sql.DB {
+	t.Helper()
+
+	ctx := context.Background()
+
+	dbContainer, err := postgres.Run(
+		ctx,
+		"postgres:latest",
+		postgres.WithDatabase("database"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).
+				WithStartupTimeout(5*time.Second)),
+	)
+	if err != nil {
+		t.Fatalf("could not start postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := dbContainer.Terminate(context.Background()); err != nil {
+			t.Errorf("could not teardown postgres container: %v", err)
+		}
+	})
+
+	dsn, err := dbContainer.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migration, err := os.ReadFile("../../migrations/000007_create_rate_limits_table.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.ExecContext(ctx, string(migration)); err != nil {
+		t.Fatalf("can't create rate_limits table: %v", err)
+	}
+
+	return db
+}
+
+func TestPostgres(t *testing.T) {
+	db := startPostgres(t)
+
+	testRateLimiter(t, func(t *testing.T, rps float64, burst int) ratelimit.RateLimiter {
+		return ratelimit.NewPostgres(db, rps, burst, 3*time.Second)
+	})
+}
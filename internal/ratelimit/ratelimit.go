@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter decides whether the client identified by key may make another
+// request.
+type RateLimiter interface {
+	// Allow records a request of key and reports whether it is within the
+	// limit. A rejected request isn't counted against the limit.
+	Allow(ctx context.Context, key string) (Result, error)
+
+	// Sweep forgets the keys that haven't made a request for idleTimeout.
+	Sweep(ctx context.Context, idleTimeout time.Duration) error
+}
+
+// Result is the outcome of a rate limit check, with what a caller needs to
+// fill the RateLimit-* and Retry-After response headers.
+type Result struct {
+	Allowed bool
+	// Limit is the number of requests a client can make in a burst.
+	Limit int
+	// Remaining is the number of requests left in the current burst.
+	Remaining int
+	// ResetAfter is how long until the client is back to a full burst.
+	ResetAfter time.Duration
+	// RetryAfter is how long until the next request would be allowed. It is
+	// zero when Allowed is true.
+	RetryAfter time.Duration
+}
+
+// Memory limits requests with a token bucket per key, kept in memory.
+type Memory struct {
+	mu      sync.Mutex
+	clients map[string]*client
+
+	limit rate.Limit
+	burst int
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewMemory returns a limiter allowing each key rps requests per second on
+// average, with bursts of up to burst requests.
+func NewMemory(rps float64, burst int) *Memory {
+	return &Memory{
+		clients: make(map[string]*client),
+		limit:   rate.Limit(rps),
+		burst:   burst,
+	}
+}
+
+// Allow consumes a token from the bucket of key, if there is one.
+func (m *Memory) Allow(ctx context.Context, key string) (Result, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, found := m.clients[key]
+	if !found {
+		c = &client{limiter: rate.NewLimiter(m.limit, m.burst)}
+		m.clients[key] = c
+	}
+	c.lastSeen = now
+
+	result := Result{Limit: m.burst}
+
+	reservation := c.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		// Give the token back, the request is rejected rather than delayed.
+		reservation.CancelAt(now)
+		result.RetryAfter = delay
+	} else {
+		result.Allowed = true
+	}
+
+	tokens := c.limiter.TokensAt(now)
+	result.Remaining = max(int(math.Floor(tokens)), 0)
+	result.ResetAfter = time.Duration((float64(m.burst) - tokens) / float64(m.limit) * float64(time.Second))
+
+	return result, nil
+}
+
+// Sweep forgets the keys that haven't been seen for idleTimeout. Their bucket
+// would be full by then anyway, unless the rate is very low.
+func (m *Memory) Sweep(ctx context.Context, idleTimeout time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, c := range m.clients {
+		if time.Since(c.lastSeen) > idleTimeout {
+			delete(m.clients, key)
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of keys currently tracked.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.clients)
+}
@@ -0,0 +1,36 @@
+package ratelimit_test
+
+import (
+	"context"
+	"pilem/internal/ratelimit"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	t.Parallel()
+
+	testRateLimiter(t, func(t *testing.T, rps float64, burst int) ratelimit.RateLimiter {
+		return ratelimit.NewMemory(rps, burst)
+	})
+}
+
+func TestMemorySweep_ForgetIdleKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	m := ratelimit.NewMemory(1, 2)
+	if _, err := m.Allow(ctx, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := m.Sweep(ctx, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Len() != 0 {
+		t.Errorf("want idle key to be forgotten, got %d keys", m.Len())
+	}
+}
@@ -0,0 +1,151 @@
+package ratelimit_test
+
+import (
+	"context"
+	"pilem/internal/ratelimit"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newLimiterFunc returns a limiter allowing rps requests per second on
+// average with bursts of burst requests.
+type newLimiterFunc func(t *testing.T, rps float64, burst int) ratelimit.RateLimiter
+
+// testRateLimiter runs the behaviour every RateLimiter implementation must
+// share. Keys are prefixed with the test name, so implementations with shared
+// state can reuse it between subtests.
+func testRateLimiter(t *testing.T, newLimiter newLimiterFunc) {
+	ctx := context.Background()
+
+	t.Run("allow burst then reject", func(t *testing.T) {
+		l := newLimiter(t, 0.001, 3)
+		key := t.Name()
+
+		for i := range 3 {
+			result, err := l.Allow(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Allowed {
+				t.Fatalf("request %d: want allowed within burst", i+1)
+			}
+			if result.Limit != 3 {
+				t.Errorf("request %d: want limit 3 got %d", i+1, result.Limit)
+			}
+			if want := 2 - i; result.Remaining != want {
+				t.Errorf("request %d: want %d remaining got %d", i+1, want, result.Remaining)
+			}
+		}
+
+		result, err := l.Allow(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Allowed {
+			t.Fatal("want request over burst to be rejected")
+		}
+		if result.Remaining != 0 {
+			t.Errorf("want 0 remaining got %d", result.Remaining)
+		}
+		if result.RetryAfter <= 0 {
+			t.Errorf("want positive retry after got %s", result.RetryAfter)
+		}
+		if result.ResetAfter <= 0 {
+			t.Errorf("want positive reset after got %s", result.ResetAfter)
+		}
+	})
+
+	t.Run("keys are independent", func(t *testing.T) {
+		l := newLimiter(t, 0.001, 1)
+
+		for _, key := range []string{t.Name() + "/a", t.Name() + "/b"} {
+			result, err := l.Allow(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Allowed {
+				t.Errorf("want first request of %s allowed", key)
+			}
+		}
+	})
+
+	t.Run("allow again after refill", func(t *testing.T) {
+		l := newLimiter(t, 20, 1)
+		key := t.Name()
+
+		if result, err := l.Allow(ctx, key); err != nil || !result.Allowed {
+			t.Fatalf("want first request allowed got %+v, %v", result, err)
+		}
+
+		result, err := l.Allow(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Allowed {
+			t.Fatal("want second request rejected")
+		}
+
+		time.Sleep(max(result.RetryAfter, 100*time.Millisecond))
+
+		if result, err := l.Allow(ctx, key); err != nil || !result.Allowed {
+			t.Errorf("want request after retry after allowed got %+v, %v", result, err)
+		}
+	})
+
+	t.Run("count concurrent requests once", func(t *testing.T) {
+		l := newLimiter(t, 0.001, 10)
+		key := t.Name()
+
+		var (
+			wg      sync.WaitGroup
+			allowed atomic.Int32
+		)
+
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for range 5 {
+					result, err := l.Allow(ctx, key)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if result.Allowed {
+						allowed.Add(1)
+					}
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		if got := allowed.Load(); got != 10 {
+			t.Errorf("want exactly 10 requests allowed got %d", got)
+		}
+	})
+
+	t.Run("sweep keeps active keys", func(t *testing.T) {
+		l := newLimiter(t, 0.001, 2)
+		key := t.Name()
+
+		if _, err := l.Allow(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := l.Sweep(ctx, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := l.Allow(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Remaining != 0 {
+			t.Errorf("want sweep to keep the count of an active key, got %d remaining", result.Remaining)
+		}
+	})
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"pilem/internal/data"
+)
+
+type contextKey string
+
+const userContextKey = contextKey("user")
+
+// contextSetUser returns a copy of r carrying user in its context.
+func contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetUser returns the user put in the context by the authenticate
+// middleware. It panics when there is none, since every request is expected
+// to go through that middleware.
+func contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
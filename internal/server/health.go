@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"pilem/helper"
+	"pilem/internal/database"
+	"time"
+)
+
+// readinessTimeout bounds the database checks of the readiness probe, so a
+// stuck database makes the probe fail instead of hang.
+const readinessTimeout = 2 * time.Second
+
+// healthcheckHandler is the liveness probe: it answers as long as the process
+// serves requests, without touching the database.
+func (s *Server) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	env := helper.Envelope{
+		"status": "available",
+		"system_info": map[string]string{
+			"environment": s.env,
+			"version":     s.version,
+		},
+	}
+
+	err := helper.WriteJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		helper.ServerErrorResponse(w, r, err)
+	}
+}
+
+// readinessHandler is the readiness probe: it pings the database and checks
+// that every migration is applied. It answers 503 when the server should not
+// get traffic yet. Without a database, the server is always ready.
+func (s *Server) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if s.sqlDB == nil {
+		err := helper.WriteJSON(w, http.StatusOK, helper.Envelope{"status": "ready"}, nil)
+		if err != nil {
+			helper.ServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	ready := true
+
+	dbHealth := s.health.Health(ctx)
+	if dbHealth["status"] != "up" {
+		ready = false
+	}
+
+	var migrationStatus any
+	status, err := database.GetMigrationStatus(ctx, s.sqlDB, s.migrations)
+	switch {
+	case err != nil:
+		helper.ContextGetLogger(r).Warn("cannot read migration status", "error", err)
+		ready = false
+		migrationStatus = map[string]string{"error": "cannot read migration status"}
+	case status.Dirty || status.Pending > 0:
+		ready = false
+		migrationStatus = status
+	default:
+		migrationStatus = status
+	}
+
+	code, readiness := http.StatusOK, "ready"
+	if !ready {
+		code, readiness = http.StatusServiceUnavailable, "unavailable"
+	}
+
+	env := helper.Envelope{
+		"status":     readiness,
+		"database":   dbHealth,
+		"migrations": migrationStatus,
+	}
+
+	err = helper.WriteJSON(w, code, env, nil)
+	if err != nil {
+		helper.ServerErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"pilem/helper"
+	"pilem/internal/database"
+	"pilem/migrations"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestHealthcheckHandler(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{env: "testing", version: "1.2.3"}
+
+	w := httptest.NewRecorder()
+	s.healthcheckHandler(w, httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("want status %d got %d", http.StatusOK, w.Code)
+	}
+
+	var got struct {
+		Status     string            `json:"status"`
+		SystemInfo map[string]string `json:"system_info"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Status != "available" || got.SystemInfo["environment"] != "testing" || got.SystemInfo["version"] != "1.2.3" {
+		t.Errorf("unexpected healthcheck %+v", got)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		version  int64
+		dirty    bool
+		wantCode int
+	}{
+		{name: "every migration applied", version: 8, wantCode: http.StatusOK},
+		{name: "pending migrations", version: 5, wantCode: http.StatusServiceUnavailable},
+		{name: "dirty migration", version: 8, dirty: true, wantCode: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"version", "dirty"}).AddRow(tt.version, tt.dirty)
+				mock.ExpectQuery("SELECT version, dirty FROM schema_migrations").WillReturnRows(rows)
+			})
+
+			s := &Server{sqlDB: db, health: database.NewService(db), migrations: migrations.FS}
+
+			w := httptest.NewRecorder()
+			s.readinessHandler(w, httptest.NewRequest(http.MethodGet, "/v1/readyz", nil))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("want status %d got %d: %s", tt.wantCode, w.Code, w.Body)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestReadinessHandler_DatabaseDown(t *testing.T) {
+	t.Parallel()
+
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {})
+	db.Close()
+
+	s := &Server{sqlDB: db, health: database.NewService(db), migrations: migrations.FS}
+
+	w := httptest.NewRecorder()
+	s.readinessHandler(w, httptest.NewRequest(http.MethodGet, "/v1/readyz", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("want status %d got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	var got struct {
+		Status   string            `json:"status"`
+		Database map[string]string `json:"database"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Status != "unavailable" || got.Database["status"] != "down" {
+		t.Errorf("unexpected readiness %+v", got)
+	}
+}
+
+func TestReadinessHandler_WithoutDatabase(t *testing.T) {
+	t.Parallel()
+
+	s := NewServer(Config{Store: "memory"}, discardLogger, nil, nil)
+
+	w := httptest.NewRecorder()
+	s.readinessHandler(w, httptest.NewRequest(http.MethodGet, "/v1/readyz", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("want status %d got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+}
@@ -0,0 +1,487 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"pilem/helper"
+	"pilem/internal/data"
+	"pilem/internal/database"
+	"pilem/internal/validator"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxImportBytes caps the body of an import, which is read as a stream
+// rather than whole like the bodies of ReadJSON.
+const maxImportBytes = 64 << 20
+
+// maxImportLineBytes caps a line of an import in NDJSON, like ReadJSON caps
+// a single movie.
+const maxImportLineBytes = 1 << 20
+
+// importBatchSize is how many valid movies an import holds before inserting
+// them.
+const importBatchSize = 1000
+
+// importTimeout replaces the read and write timeouts of the server for an
+// import, whose body can take a while to upload and insert.
+const importTimeout = 5 * time.Minute
+
+// importInsertTimeout bounds the transaction of an import, which only begins
+// once the whole body is uploaded and validated.
+const importInsertTimeout = time.Minute
+
+// movieDecoder reads the movies of an import body, calling fn for each of
+// them with the validator holding the errors of the fields it couldn't
+// parse. Errors that prevent reading the rest of the body are returned, and
+// so is the first error of fn, which stops the reading.
+type movieDecoder func(body io.Reader, fn func(movie *data.Movie, v *validator.Validator) error) error
+
+// movieDecoders are the decoders of the media types accepted for an import.
+var movieDecoders = map[string]movieDecoder{
+	"text/csv":             decodeMoviesCSV,
+	"application/x-ndjson": decodeMoviesNDJSON,
+}
+
+// importRow is the outcome of a movie of an import: the id it was created
+// with, or why it was rejected.
+type importRow struct {
+	// Row is the position of the movie in the body, from 1, not counting the
+	// header of a CSV body.
+	Row    int               `json:"row"`
+	ID     int64             `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+
+	movie *data.Movie
+}
+
+// importBodyError is an error in the body of an import, as opposed to an
+// error of the database.
+type importBodyError struct {
+	err error
+}
+
+func (e *importBodyError) Error() string {
+	return e.err.Error()
+}
+
+func (e *importBodyError) Unwrap() error {
+	return e.err
+}
+
+// importBodyReader wraps the errors of reading an import body in an
+// *importBodyError, to tell them from the errors of the spool file.
+type importBodyReader struct {
+	r io.Reader
+}
+
+func (r importBodyReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = &importBodyError{err: err}
+	}
+
+	return n, err
+}
+
+// ImportMoviesHandler creates the movies of a CSV or NDJSON body in one
+// transaction. Rows failing validation are skipped and reported, along with
+// the id of every movie created. With dry_run=true the rows are only
+// validated.
+//
+// The body is spooled to a temporary file and validated before the
+// transaction begins, so a slow upload doesn't hold a connection of the
+// database, then read again to insert the movies in batches.
+//
+// The movies of a MemoryMovieStore aren't part of the transaction, so the
+// batches inserted before an error of the store are kept.
+func (s *Server) ImportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	dryRun := helper.ReadBool(r.URL.Query(), "dry_run", false, v)
+
+	if !v.Valid() {
+		helper.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	decode, ok := movieDecoders[mediaType]
+	if !ok {
+		helper.UnsupportedMediaTypeResponse(w, r, "text/csv", "application/x-ndjson")
+		return
+	}
+
+	// The server timeouts are sized for the other requests. The errors only
+	// mean that the writer can't set deadlines, like in tests.
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Now().Add(importTimeout))
+	_ = rc.SetWriteDeadline(time.Now().Add(importTimeout))
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	var (
+		body  io.Reader = r.Body
+		spool *os.File
+	)
+
+	if !dryRun {
+		var err error
+
+		spool, err = spoolImport(r.Body)
+		if err != nil {
+			importErrorResponse(w, r, err)
+			return
+		}
+		defer func() {
+			spool.Close()
+			os.Remove(spool.Name())
+		}()
+
+		body = spool
+	}
+
+	rows, valid, err := importMovies(body, decode, nil)
+	if err == nil && !dryRun && valid > 0 {
+		rows, err = s.insertImport(r.Context(), spool, decode)
+	}
+	if err != nil {
+		importErrorResponse(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	if !dryRun && valid > 0 {
+		status = http.StatusCreated
+	}
+
+	report := map[string]any{
+		"dry_run": dryRun,
+		"valid":   valid,
+		"invalid": len(rows) - valid,
+		"rows":    rows,
+	}
+
+	err = helper.WriteJSON(w, status, helper.Envelope{"import": report}, nil)
+	if err != nil {
+		helper.ServerErrorResponse(w, r, err)
+	}
+}
+
+// spoolImport copies body to a temporary file, which the caller must close
+// and remove, and rewinds it.
+func spoolImport(body io.Reader) (*os.File, error) {
+	f, err := os.CreateTemp("", "pilem-import-*")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(f, importBodyReader{r: body})
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// insertImport inserts the valid movies of the spooled body in one
+// transaction and returns the rows of the body. The body is read again from
+// the start on every attempt of the transaction, so a retry inserts every
+// movie again.
+func (s *Server) insertImport(ctx context.Context, spool io.ReadSeeker, decode movieDecoder) ([]*importRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, importInsertTimeout)
+	defer cancel()
+
+	var rows []*importRow
+
+	err := s.db.WithTx(ctx, nil, func(tx database.Models) error {
+		_, err := spool.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		rows, _, err = importMovies(spool, decode, func(movies []*data.Movie) error {
+			return tx.Movies.InsertMany(ctx, movies)
+		})
+		return err
+	})
+
+	return rows, err
+}
+
+// importErrorResponse answers an import that failed with err.
+func importErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		maxBytesError *http.MaxBytesError
+		bodyError     *importBodyError
+	)
+
+	switch {
+	case errors.As(err, &maxBytesError):
+		helper.BadRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+	case errors.As(err, &bodyError):
+		helper.BadRequestResponse(w, r, bodyError.err)
+	default:
+		movieWriteErrorResponse(w, r, err)
+	}
+}
+
+// importMovies reads the movies of body with decode and returns a row for
+// each of them, along with the number of valid ones. The valid movies are
+// given to insert in batches of importBatchSize as they are read, so only a
+// batch is held in memory, or only counted when insert is nil. The errors of
+// the body are returned as an *importBodyError.
+func importMovies(body io.Reader, decode movieDecoder, insert func(movies []*data.Movie) error) ([]*importRow, int, error) {
+	var (
+		rows      []*importRow
+		valid     int
+		batch     []*importRow
+		insertErr error
+	)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		movies := make([]*data.Movie, len(batch))
+		for i, row := range batch {
+			movies[i] = row.movie
+		}
+
+		err := insert(movies)
+		if err != nil {
+			return err
+		}
+
+		for _, row := range batch {
+			row.ID = row.movie.ID
+			row.movie = nil
+		}
+		batch = batch[:0]
+
+		return nil
+	}
+
+	err := decode(body, func(movie *data.Movie, v *validator.Validator) error {
+		row := &importRow{Row: len(rows) + 1}
+		rows = append(rows, row)
+
+		if data.ValidateMovie(v, movie); !v.Valid() {
+			row.Errors = v.Errors
+			return nil
+		}
+
+		valid++
+
+		if insert == nil {
+			return nil
+		}
+
+		row.movie = movie
+		batch = append(batch, row)
+
+		if len(batch) < importBatchSize {
+			return nil
+		}
+
+		insertErr = flush()
+		return insertErr
+	})
+
+	switch {
+	case insertErr != nil:
+		return nil, 0, insertErr
+	case err != nil:
+		return nil, 0, &importBodyError{err: err}
+	case len(rows) == 0:
+		return nil, 0, &importBodyError{err: errors.New("body must contain at least one movie")}
+	}
+
+	err = flush()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return rows, valid, nil
+}
+
+// runtimeFormatMessage is the error of a runtime that is neither a number of
+// minutes nor a string like "102 mins".
+const runtimeFormatMessage = `must be an integer or a string like "102 mins"`
+
+// decodeMoviesCSV reads movies from CSV with a header row naming the columns
+// among title, year, runtime and genres, in any order. The genres are
+// separated by commas within their field, and none of them may be empty.
+func decodeMoviesCSV(body io.Reader, fn func(movie *data.Movie, v *validator.Validator) error) error {
+	cr := csv.NewReader(body)
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("body must not be empty")
+		}
+		return csvError(err)
+	}
+
+	columns := make([]string, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		switch {
+		case !slices.Contains([]string{"title", "year", "runtime", "genres"}, name):
+			return fmt.Errorf("body contains unknown column %q", name)
+		case slices.Contains(columns[:i], name):
+			return fmt.Errorf("body contains column %q more than once", name)
+		}
+
+		columns[i] = name
+	}
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return csvError(err)
+		}
+
+		movie := &data.Movie{}
+		v := validator.New()
+
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+
+			switch columns[i] {
+			case "title":
+				movie.Title = value
+			case "year":
+				year, err := strconv.ParseInt(value, 10, 32)
+				if err != nil {
+					v.AddError("year", "must be an integer")
+				}
+				movie.Year = int32(year)
+			case "runtime":
+				// The runtime is read like in JSON, as a number or a string.
+				if _, err := strconv.Atoi(value); err != nil {
+					value = strconv.Quote(value)
+				}
+				if err := movie.Runtime.UnmarshalJSON([]byte(value)); err != nil {
+					v.AddError("runtime", runtimeFormatMessage)
+				}
+			case "genres":
+				movie.Genres = strings.Split(value, ",")
+				for i := range movie.Genres {
+					movie.Genres[i] = strings.TrimSpace(movie.Genres[i])
+					// Like "Drama,,Comedy" or a trailing comma.
+					v.Check(movie.Genres[i] != "", "genres", "must not contain empty values")
+				}
+			}
+		}
+
+		err = fn(movie, v)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// csvError returns the error of a body that isn't valid CSV.
+func csvError(err error) error {
+	var parseError *csv.ParseError
+	if errors.As(err, &parseError) {
+		return fmt.Errorf("body contains badly-formed CSV: %v", parseError)
+	}
+
+	return err
+}
+
+// decodeMoviesNDJSON reads movies from newline-delimited JSON, one object
+// like the body of CreateMovieHandler per line. Blank lines are skipped.
+func decodeMoviesNDJSON(body io.Reader, fn func(movie *data.Movie, v *validator.Validator) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, maxImportLineBytes)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var input struct {
+			Title   string       `json:"title"`
+			Year    int32        `json:"year"`
+			Runtime data.Runtime `json:"runtime"`
+			Genres  []string     `json:"genres"`
+		}
+
+		v := validator.New()
+
+		dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		dec.DisallowUnknownFields()
+
+		// Decode keeps decoding after a field of the wrong type or an
+		// unknown one, and returns the error of the first of them.
+		err := dec.Decode(&input)
+		if err != nil {
+			var unmarshalTypeError *json.UnmarshalTypeError
+
+			switch {
+			case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+				field, _, _ := strings.Cut(unmarshalTypeError.Field, ".")
+				v.AddError(field, "has an incorrect JSON type")
+			case errors.Is(err, data.ErrInvalidRuntimeFormat):
+				v.AddError("runtime", runtimeFormatMessage)
+			case strings.HasPrefix(err.Error(), "json: unknown field "):
+				fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+				return fmt.Errorf("body contains unknown key %s on line %d", fieldName, line)
+			default:
+				return fmt.Errorf("body contains badly-formed JSON on line %d", line)
+			}
+		}
+
+		// Like in ReadJSON, anything after the movie makes a second Decode
+		// fail with another error than io.EOF.
+		if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+			return fmt.Errorf("body must only contain a single JSON value on line %d", line)
+		}
+
+		err = fn(&data.Movie{
+			Title:   input.Title,
+			Year:    input.Year,
+			Runtime: input.Runtime,
+			Genres:  input.Genres,
+		}, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := scanner.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Errorf("body must not contain lines larger than %d bytes", maxImportLineBytes)
+	}
+
+	return err
+}
@@ -0,0 +1,365 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"pilem/helper"
+	"pilem/internal/data"
+	"pilem/internal/database"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/go-cmp/cmp"
+	"github.com/lib/pq"
+)
+
+func TestImportMoviesHandler_CreateValidRowsFromCSV(t *testing.T) {
+	t.Parallel()
+
+	movies := database.NewMemoryMovieStore()
+	s := &Server{db: database.Models{Movies: movies}}
+
+	body := "title,year,runtime,genres\n" +
+		"Overlord,2018,110 mins,\"Action, War\"\n" +
+		",1700,abc,Drama\n" +
+		"Arrival,2016,116,Sci-Fi\n" +
+		"Heat,1995,170,\"Crime,,Drama\"\n" +
+		"Ronin,1998,122,\"Action, \"\n"
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies/import", strings.NewReader(body))
+	r.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	w := httptest.NewRecorder()
+	s.ImportMoviesHandler(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("want status %d got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+
+	want := `{"import":{"dry_run":false,"invalid":3,"rows":[` +
+		`{"row":1,"id":1},` +
+		`{"row":2,"errors":{"runtime":"must be an integer or a string like \"102 mins\"","title":"must be provided","year":"must be 1888 or later"}},` +
+		`{"row":3,"id":2},` +
+		`{"row":4,"errors":{"genres":"must not contain empty values"}},` +
+		`{"row":5,"errors":{"genres":"must not contain empty values"}}` +
+		`],"valid":2}}`
+	if got := strings.TrimSpace(w.Body.String()); want != got {
+		t.Error(cmp.Diff(want, got))
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil)
+	r.SetPathValue("id", "1")
+	w = httptest.NewRecorder()
+	s.GetMovieHandler(w, r)
+
+	want = `{"movie":{"id":1,"title":"Overlord","year":2018,"runtime":"110 mins","genres":["Action","War"],"version":1}}`
+	if got := strings.TrimSpace(w.Body.String()); got != want {
+		t.Errorf("want %s got %s", want, got)
+	}
+}
+
+func TestImportMoviesHandler_DryRunNDJSON(t *testing.T) {
+	t.Parallel()
+
+	movies := database.NewMemoryMovieStore()
+	s := &Server{db: database.Models{Movies: movies}}
+
+	body := `{"title":"Overlord","year":2018,"runtime":"110 mins","genres":["Action"]}` + "\n" +
+		"\n" +
+		`{"title":"Arrival","year":"2016","runtime":116,"genres":["Sci-Fi"]}` + "\n"
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies/import?dry_run=true", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	s.ImportMoviesHandler(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("want status %d got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	want := `{"import":{"dry_run":true,"invalid":1,"rows":[` +
+		`{"row":1},` +
+		`{"row":2,"errors":{"year":"has an incorrect JSON type"}}` +
+		`],"valid":1}}`
+	if got := strings.TrimSpace(w.Body.String()); want != got {
+		t.Error(cmp.Diff(want, got))
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil)
+	r.SetPathValue("id", "1")
+	w = httptest.NewRecorder()
+	s.GetMovieHandler(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("dry run: want status %d got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestImportMoviesHandler_InsertInTransaction(t *testing.T) {
+	t.Parallel()
+
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO movies \(title, year, runtime, genres\)\s+VALUES \(\$1, \$2, \$3, \$4\), \(\$5, \$6, \$7, \$8\)`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "version"}).
+				AddRow(8, time.Now(), 1).
+				AddRow(7, time.Now(), 1))
+		mock.ExpectCommit()
+	})
+
+	s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout)}
+
+	body := `{"title":"Overlord","year":2018,"runtime":110,"genres":["Action"]}` + "\n" +
+		`{"title":"Arrival","year":2016,"runtime":116,"genres":["Sci-Fi"]}` + "\n"
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies/import", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	s.ImportMoviesHandler(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("want status %d got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+
+	// The ids come back out of order, but are given in the order of the rows.
+	want := `{"import":{"dry_run":false,"invalid":0,"rows":[{"row":1,"id":7},{"row":2,"id":8}],"valid":2}}`
+	if got := strings.TrimSpace(w.Body.String()); want != got {
+		t.Error(cmp.Diff(want, got))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportMoviesHandler_RetryFromSpooledBody(t *testing.T) {
+	t.Parallel()
+
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO movies").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "version"}).AddRow(1, time.Now(), 1))
+		mock.ExpectCommit().WillReturnError(&pq.Error{Code: "40001"})
+		// The retry reads the body again from the spool file.
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO movies").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "version"}).AddRow(2, time.Now(), 1))
+		mock.ExpectCommit()
+	})
+
+	s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout)}
+
+	body := `{"title":"Overlord","year":2018,"runtime":110,"genres":["Action"]}` + "\n"
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies/import", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	s.ImportMoviesHandler(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("want status %d got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+
+	want := `{"import":{"dry_run":false,"invalid":0,"rows":[{"row":1,"id":2}],"valid":1}}`
+	if got := strings.TrimSpace(w.Body.String()); want != got {
+		t.Error(cmp.Diff(want, got))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// batchRecorder is a MovieStore recording the size of the batches given to
+// InsertMany, and whether their context had a deadline.
+type batchRecorder struct {
+	database.MovieStore
+	batches   []int
+	deadlines []bool
+}
+
+func (s *batchRecorder) InsertMany(ctx context.Context, movies []*data.Movie) error {
+	_, ok := ctx.Deadline()
+
+	s.batches = append(s.batches, len(movies))
+	s.deadlines = append(s.deadlines, ok)
+
+	return s.MovieStore.InsertMany(ctx, movies)
+}
+
+func TestImportMoviesHandler_InsertInBatches(t *testing.T) {
+	t.Parallel()
+
+	movies := &batchRecorder{MovieStore: database.NewMemoryMovieStore()}
+	s := &Server{db: database.Models{Movies: movies}}
+
+	var body strings.Builder
+	body.WriteString("title,year,runtime,genres\n")
+	for i := range importBatchSize + 1 {
+		// An invalid row doesn't count towards the batch.
+		if i == 1 {
+			body.WriteString("Untitled,1700,90,Drama\n")
+		}
+		fmt.Fprintf(&body, "Movie %d,2016,90,Drama\n", i)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies/import", strings.NewReader(body.String()))
+	r.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	s.ImportMoviesHandler(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("want status %d got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+
+	if want := []int{importBatchSize, 1}; !slices.Equal(movies.batches, want) {
+		t.Errorf("want batches %v got %v", want, movies.batches)
+	}
+
+	if slices.Contains(movies.deadlines, false) {
+		t.Errorf("want every batch inserted with a deadline got %v", movies.deadlines)
+	}
+
+	var got struct {
+		Import struct {
+			Valid   int         `json:"valid"`
+			Invalid int         `json:"invalid"`
+			Rows    []importRow `json:"rows"`
+		} `json:"import"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Import.Valid != importBatchSize+1 || got.Import.Invalid != 1 || len(got.Import.Rows) != importBatchSize+2 {
+		t.Fatalf("want %d valid and 1 invalid rows got %d and %d", importBatchSize+1, got.Import.Valid, got.Import.Invalid)
+	}
+
+	if last := got.Import.Rows[len(got.Import.Rows)-1]; last.ID != importBatchSize+1 {
+		t.Errorf("want the last row created with id %d got %+v", importBatchSize+1, last)
+	}
+}
+
+func TestImportMoviesHandler_ValidateBodyBeforeTransaction(t *testing.T) {
+	t.Parallel()
+
+	// A whole batch precedes the error, but nothing reaches the database.
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {})
+
+	s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout)}
+
+	var body strings.Builder
+	for range importBatchSize {
+		body.WriteString(`{"title":"Overlord","year":2018,"runtime":110,"genres":["Action"]}` + "\n")
+	}
+	body.WriteString(`{"title":` + "\n")
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies/import", strings.NewReader(body.String()))
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	s.ImportMoviesHandler(w, r)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("want status %d got %d: %s", http.StatusUnprocessableEntity, w.Code, w.Body)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportMoviesHandler_RejectBadBody(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		contentType string
+		query       string
+		body        string
+		wantCode    int
+		wantError   any
+	}{
+		{
+			name:        "unsupported media type",
+			contentType: "application/json",
+			body:        `[]`,
+			wantCode:    http.StatusUnsupportedMediaType,
+			wantError:   "the request body must be text/csv or application/x-ndjson",
+		},
+		{
+			name:        "invalid dry_run",
+			contentType: "text/csv",
+			query:       "?dry_run=maybe",
+			body:        "title\n",
+			wantCode:    http.StatusUnprocessableEntity,
+			wantError:   map[string]string{"dry_run": "must be a boolean value"},
+		},
+		{
+			name:        "unknown column",
+			contentType: "text/csv",
+			body:        "title,director\nOverlord,Julius Avery\n",
+			wantCode:    http.StatusUnprocessableEntity,
+			wantError:   `body contains unknown column "director"`,
+		},
+		{
+			name:        "wrong number of fields",
+			contentType: "text/csv",
+			body:        "title,year\nOverlord\n",
+			wantCode:    http.StatusUnprocessableEntity,
+			wantError:   "body contains badly-formed CSV: record on line 2: wrong number of fields",
+		},
+		{
+			name:        "no rows",
+			contentType: "text/csv",
+			body:        "title,year\n",
+			wantCode:    http.StatusUnprocessableEntity,
+			wantError:   "body must contain at least one movie",
+		},
+		{
+			name:        "badly-formed JSON",
+			contentType: "application/x-ndjson",
+			body:        `{"title":"Overlord"}` + "\n" + `{"title":` + "\n",
+			wantCode:    http.StatusUnprocessableEntity,
+			wantError:   "body contains badly-formed JSON on line 2",
+		},
+		{
+			name:        "unknown JSON key",
+			contentType: "application/x-ndjson",
+			body:        `{"title":"Overlord"}` + "\n" + `{"title":"Arrival","director":"Denis Villeneuve"}` + "\n",
+			wantCode:    http.StatusUnprocessableEntity,
+			wantError:   `body contains unknown key "director" on line 2`,
+		},
+		{
+			name:        "several JSON values on a line",
+			contentType: "application/x-ndjson",
+			body:        `{"title":"Overlord"}}` + "\n",
+			wantCode:    http.StatusUnprocessableEntity,
+			wantError:   "body must only contain a single JSON value on line 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{db: database.Models{Movies: database.NewMemoryMovieStore()}}
+
+			r := httptest.NewRequest(http.MethodPost, "/v1/movies/import"+tt.query, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			s.ImportMoviesHandler(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("want status %d got %d", tt.wantCode, w.Code)
+			}
+
+			want, err := helper.AnyToJSON(helper.Envelope{"error": tt.wantError})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.TrimSpace(w.Body.String()); want != got {
+				t.Error(cmp.Diff(want, got))
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// metrics holds the request counters of a Server. They are expvar values so
+// they can be served as is by /debug/vars, but they aren't published in the
+// global expvar registry so several servers can live in one process.
+type metrics struct {
+	totalRequestsReceived           expvar.Int
+	totalResponsesSent              expvar.Int
+	totalProcessingTimeMicroseconds expvar.Int
+	inFlightRequests                expvar.Int
+	// totalResponsesSentByStatus is keyed by status code.
+	totalResponsesSentByStatus expvar.Map
+}
+
+// metricsResponseWriter records the status code written through it.
+type metricsResponseWriter struct {
+	wrapped       http.ResponseWriter
+	statusCode    int
+	headerWritten bool
+}
+
+func (mw *metricsResponseWriter) Header() http.Header {
+	return mw.wrapped.Header()
+}
+
+func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
+	mw.wrapped.WriteHeader(statusCode)
+
+	if !mw.headerWritten {
+		mw.statusCode = statusCode
+		mw.headerWritten = true
+	}
+}
+
+func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
+	mw.headerWritten = true
+	return mw.wrapped.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.wrapped
+}
+
+// recordMetrics counts the requests, the responses by status code, the
+// requests in flight and the time spent processing them. A panic is counted
+// as the 500 that recoverPanic, further out, responds with.
+func (s *Server) recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		s.metrics.totalRequestsReceived.Add(1)
+		s.metrics.inFlightRequests.Add(1)
+
+		mw := &metricsResponseWriter{wrapped: w, statusCode: http.StatusOK}
+
+		// The panic isn't recovered here, so recoverPanic still logs the
+		// stack where it happened.
+		panicked := true
+
+		defer func() {
+			statusCode := mw.statusCode
+			if panicked && !mw.headerWritten {
+				statusCode = http.StatusInternalServerError
+			}
+
+			s.metrics.inFlightRequests.Add(-1)
+			s.metrics.totalResponsesSent.Add(1)
+			s.metrics.totalResponsesSentByStatus.Add(strconv.Itoa(statusCode), 1)
+			s.metrics.totalProcessingTimeMicroseconds.Add(time.Since(start).Microseconds())
+		}()
+
+		next.ServeHTTP(mw, r)
+		panicked = false
+	})
+}
+
+// vars returns the variables served by /debug/vars on top of the ones in the
+// global expvar registry.
+func (s *Server) vars() map[string]expvar.Var {
+	vars := map[string]expvar.Var{
+		"version": expvar.Func(func() any {
+			return s.version
+		}),
+		"goroutines": expvar.Func(func() any {
+			return runtime.NumGoroutine()
+		}),
+		"total_requests_received":            &s.metrics.totalRequestsReceived,
+		"total_responses_sent":               &s.metrics.totalResponsesSent,
+		"total_processing_time_microseconds": &s.metrics.totalProcessingTimeMicroseconds,
+		"in_flight_requests":                 &s.metrics.inFlightRequests,
+		"total_responses_sent_by_status":     &s.metrics.totalResponsesSentByStatus,
+	}
+
+	if s.sqlDB != nil {
+		vars["database"] = expvar.Func(func() any {
+			return s.sqlDB.Stats()
+		})
+	}
+
+	return vars
+}
+
+// debugVarsHandler serves the same JSON document as expvar.Handler, with the
+// server variables added and without cmdline, whose flags hold secrets like
+// the database DSN.
+func (s *Server) debugVarsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	vars := s.vars()
+	expvar.Do(func(kv expvar.KeyValue) {
+		if _, exists := vars[kv.Key]; !exists && kv.Key != "cmdline" {
+			vars[kv.Key] = kv.Value
+		}
+	})
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	fmt.Fprintf(w, "{\n")
+	for i, name := range names {
+		if i > 0 {
+			fmt.Fprintf(w, ",\n")
+		}
+		key, _ := json.Marshal(name)
+		fmt.Fprintf(w, "%s: %s", key, vars[name])
+	}
+	fmt.Fprintf(w, "\n}\n")
+}
+
+// prometheusHandler serves the metrics in the Prometheus text exposition
+// format.
+func (s *Server) prometheusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeMetric(w, "pilem_build_info", "gauge", "Build information of the running binary.",
+		fmt.Sprintf("{version=%q} 1", s.version))
+	writeMetric(w, "pilem_http_requests_total", "counter", "Total number of HTTP requests received.",
+		" "+s.metrics.totalRequestsReceived.String())
+	writeMetric(w, "pilem_http_requests_in_flight", "gauge", "Number of HTTP requests being processed.",
+		" "+s.metrics.inFlightRequests.String())
+	writeMetric(w, "pilem_http_request_processing_seconds_total", "counter", "Total time spent processing HTTP requests.",
+		" "+formatFloat(float64(s.metrics.totalProcessingTimeMicroseconds.Value())/1e6))
+
+	var byStatus []string
+	s.metrics.totalResponsesSentByStatus.Do(func(kv expvar.KeyValue) {
+		byStatus = append(byStatus, fmt.Sprintf("{code=%q} %s", kv.Key, kv.Value))
+	})
+	writeMetric(w, "pilem_http_responses_total", "counter", "Total number of HTTP responses sent by status code.", byStatus...)
+
+	writeMetric(w, "go_goroutines", "gauge", "Number of goroutines that currently exist.",
+		" "+strconv.Itoa(runtime.NumGoroutine()))
+
+	if s.sqlDB != nil {
+		writeDBStats(w, s.sqlDB.Stats())
+	}
+}
+
+func writeDBStats(w io.Writer, stats sql.DBStats) {
+	writeMetric(w, "pilem_db_max_open_connections", "gauge", "Maximum number of open connections to the database.",
+		" "+strconv.Itoa(stats.MaxOpenConnections))
+	writeMetric(w, "pilem_db_open_connections", "gauge", "Number of established connections to the database.",
+		" "+strconv.Itoa(stats.OpenConnections))
+	writeMetric(w, "pilem_db_in_use_connections", "gauge", "Number of connections currently in use.",
+		" "+strconv.Itoa(stats.InUse))
+	writeMetric(w, "pilem_db_idle_connections", "gauge", "Number of idle connections.",
+		" "+strconv.Itoa(stats.Idle))
+	writeMetric(w, "pilem_db_wait_count_total", "counter", "Total number of connections waited for.",
+		" "+strconv.FormatInt(stats.WaitCount, 10))
+	writeMetric(w, "pilem_db_wait_duration_seconds_total", "counter", "Total time blocked waiting for a new connection.",
+		" "+formatFloat(stats.WaitDuration.Seconds()))
+	writeMetric(w, "pilem_db_max_idle_closed_total", "counter", "Total number of connections closed due to SetMaxIdleConns.",
+		" "+strconv.FormatInt(stats.MaxIdleClosed, 10))
+	writeMetric(w, "pilem_db_max_idle_time_closed_total", "counter", "Total number of connections closed due to SetConnMaxIdleTime.",
+		" "+strconv.FormatInt(stats.MaxIdleTimeClosed, 10))
+	writeMetric(w, "pilem_db_max_lifetime_closed_total", "counter", "Total number of connections closed due to SetConnMaxLifetime.",
+		" "+strconv.FormatInt(stats.MaxLifetimeClosed, 10))
+}
+
+// writeMetric writes the HELP and TYPE lines of a metric followed by one
+// sample per element of samples, each being the labels, if any, and the
+// value.
+func writeMetric(w io.Writer, name, metricType, help string, samples ...string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+
+	for _, sample := range samples {
+		fmt.Fprintf(w, "%s%s\n", name, sample)
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecordMetrics_CountResponsesByStatus(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{logger: discardLogger, version: "1.2.3"}
+	handler := s.RegisterRoutes()
+
+	for _, path := range []string{"/", "/v1/unknown", "/v1/unknown"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// /metrics needs a permission, so its handler is called directly, still
+	// through recordMetrics.
+	w := httptest.NewRecorder()
+	s.recordMetrics(http.HandlerFunc(s.prometheusHandler)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body, err := io.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(body)
+
+	for _, want := range []string{
+		"# TYPE pilem_http_requests_total counter\npilem_http_requests_total 4\n",
+		`pilem_http_responses_total{code="200"} 1` + "\n",
+		`pilem_http_responses_total{code="404"} 2` + "\n",
+		"pilem_http_requests_in_flight 1\n",
+		`pilem_build_info{version="1.2.3"} 1` + "\n",
+		"# TYPE go_goroutines gauge\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want metrics to contain %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestRecordMetrics_CountPanicAsServerError(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{logger: discardLogger}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	w := httptest.NewRecorder()
+	s.recoverPanic(s.recordMetrics(next)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("want status %d got %d", http.StatusInternalServerError, w.Code)
+	}
+
+	if got := s.metrics.totalResponsesSentByStatus.Get("500"); got == nil || got.String() != "1" {
+		t.Errorf("want one 500 response counted got %v", got)
+	}
+
+	if got := s.metrics.inFlightRequests.Value(); got != 0 {
+		t.Errorf("want no request in flight got %d", got)
+	}
+}
+
+func TestDebugVarsHandler_ServeExpvarJSON(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{logger: discardLogger, version: "1.2.3"}
+
+	w := httptest.NewRecorder()
+	s.debugVarsHandler(w, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+
+	var got map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("can't decode /debug/vars. Err:%v", err)
+	}
+
+	for _, key := range []string{"version", "goroutines", "memstats", "total_requests_received", "total_responses_sent_by_status"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("want /debug/vars to contain %q", key)
+		}
+	}
+
+	if got["version"] != "1.2.3" {
+		t.Errorf("want version 1.2.3 got %v", got["version"])
+	}
+
+	if _, ok := got["cmdline"]; ok {
+		t.Error("want /debug/vars without cmdline")
+	}
+}
+
+func TestMetricsEndpoints_RequireAuthentication(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{logger: discardLogger}
+	handler := s.RegisterRoutes()
+
+	for _, path := range []string{"/debug/vars", "/metrics"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: want status %d got %d", path, http.StatusUnauthorized, w.Code)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"pilem/helper"
+	"pilem/internal/data"
+	"pilem/internal/database"
+	"pilem/internal/validator"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recoverPanic turns a panic in the rest of the chain into a logged JSON 500,
+// instead of letting net/http drop the connection.
+func (s *Server) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			pv := recover()
+			if pv == nil {
+				return
+			}
+
+			// ErrAbortHandler is net/http's way to abort a response on
+			// purpose, so let it through.
+			if pv == http.ErrAbortHandler {
+				panic(pv)
+			}
+
+			// The connection may be in a bad state, make net/http close it
+			// after the response is sent.
+			w.Header().Set("Connection", "close")
+
+			logger := s.newRequestLogger(r, w.Header().Get("X-Request-Id")).
+				With("stack", string(debug.Stack()))
+
+			helper.ServerErrorResponse(w, helper.ContextSetLogger(r, logger), fmt.Errorf("panic: %v", pv))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestLogger gives every request an ID, returned in the X-Request-Id
+// header, and puts a logger carrying the request ID, method and URI in the
+// request context for the handlers and helper responses to use.
+func (s *Server) requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 8)
+		_, err := rand.Read(b)
+		if err != nil {
+			helper.ServerErrorResponse(w, r, err)
+			return
+		}
+		requestID := hex.EncodeToString(b)
+
+		w.Header().Set("X-Request-Id", requestID)
+
+		next.ServeHTTP(w, helper.ContextSetLogger(r, s.newRequestLogger(r, requestID)))
+	})
+}
+
+// newRequestLogger returns the server logger with the attributes identifying
+// the request.
+func (s *Server) newRequestLogger(r *http.Request, requestID string) *slog.Logger {
+	return s.logger.With(
+		"request_id", requestID,
+		"method", r.Method,
+		"uri", r.URL.RequestURI(),
+	)
+}
+
+// enableCORS lets the trusted origins make cross-origin requests, and answers
+// their preflight requests.
+func (s *Server) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response depends on these headers, so caches must not share it
+		// between origins.
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+
+		if origin != "" && slices.Contains(s.corsTrustedOrigins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+
+			// A preflight request is an OPTIONS request with an
+			// Access-Control-Request-Method header.
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimit rejects the requests of a client that went over its rate limit
+// with a 429. Every response carries the RateLimit-* headers, except when the
+// limiter fails and the request is let through or rejected with a 503,
+// depending on limiterFailOpen.
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		result, err := s.limiter.Allow(r.Context(), clientIP(r, s.trustedProxies))
+		if err != nil {
+			// A broken limiter mustn't take the whole API down with it.
+			if s.limiterFailOpen {
+				helper.ContextGetLogger(r).Warn("rate limiter failed, allowing request", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			helper.ContextGetLogger(r).Error("rate limiter failed, rejecting request", "error", err)
+			helper.ServiceUnavailableResponse(w, r, err)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			helper.RateLimitExceededResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ceilSeconds rounds d up to whole seconds, as the rate limit headers want.
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// clientIP returns the IP of the client that made r. X-Forwarded-For and
+// X-Real-IP are only used when the request comes from a trusted proxy, since
+// anyone can send them. X-Forwarded-For is walked from the right, skipping
+// the trusted proxies, so a client can't spoof its IP by prepending entries.
+func clientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	ip := remote.Addr().Unmap()
+
+	if !isTrusted(ip, trustedProxies) {
+		return ip.String()
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			ip = hop.Unmap()
+
+			if !isTrusted(ip, trustedProxies) {
+				return ip.String()
+			}
+		}
+
+		return ip.String()
+	}
+
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.Unmap().String()
+	}
+
+	return ip.String()
+}
+
+func isTrusted(ip netip.Addr, trustedProxies []netip.Prefix) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authenticate puts the user owning the bearer token of the Authorization
+// header in the request context, or data.AnonymousUser when there is no
+// header.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response depends on the Authorization header, so caches must
+		// not share it between clients.
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+
+		if authorizationHeader == "" {
+			r = contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			helper.InvalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		token := headerParts[1]
+
+		v := validator.New()
+
+		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			helper.InvalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := s.db.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, database.ErrRecordNotFound):
+				helper.InvalidAuthenticationTokenResponse(w, r)
+			default:
+				databaseErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = contextSetUser(r, user)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAuthenticatedUser rejects requests made by the anonymous user.
+func (s *Server) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := contextGetUser(r)
+
+		if user.IsAnonymous() {
+			helper.AuthenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// requireActivatedUser rejects requests made by the anonymous user or by a
+// user who hasn't activated their account yet.
+func (s *Server) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := contextGetUser(r)
+
+		if !user.Activated {
+			helper.InactiveAccountResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return s.requireAuthenticatedUser(fn)
+}
+
+// requirePermission rejects requests whose user isn't activated or hasn't
+// been granted the permission code.
+func (s *Server) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := contextGetUser(r)
+
+		permissions, err := s.db.Permissions.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			databaseErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include(code) {
+			helper.NotPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return s.requireActivatedUser(fn)
+}
@@ -0,0 +1,389 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"pilem/helper"
+	"pilem/internal/data"
+	"pilem/internal/database"
+	"pilem/internal/ratelimit"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAuthenticate_SetAnonymousUserWithoutHeader(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{}
+
+	var got *data.User
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = contextGetUser(r)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	s.authenticate(next).ServeHTTP(w, r)
+
+	if got == nil || !got.IsAnonymous() {
+		t.Errorf("want anonymous user got %v", got)
+	}
+}
+
+func TestAuthenticate_RejectMalformedToken(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("next handler must not be called")
+	})
+
+	for _, header := range []string{"Basic abc", "Bearer", "Bearer short"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", header)
+		w := httptest.NewRecorder()
+		s.authenticate(next).ServeHTTP(w, r)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%q: want status %d got %d", header, http.StatusUnauthorized, w.Code)
+		}
+
+		if got := w.Header().Get("WWW-Authenticate"); got != "Bearer" {
+			t.Errorf("%q: want WWW-Authenticate Bearer got %q", header, got)
+		}
+	}
+}
+
+func TestAuthenticate_SetUserOwningToken(t *testing.T) {
+	t.Parallel()
+
+	token := "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		rows := sqlmock.NewRows([]string{"id", "created_at", "name", "email", "password_hash", "activated", "version"}).
+			AddRow(3, time.Now(), "alice", "alice@example.com", []byte("hash"), true, 1)
+		mock.ExpectQuery("INNER JOIN tokens").
+			WithArgs(sqlmock.AnyArg(), data.ScopeAuthentication, sqlmock.AnyArg()).
+			WillReturnRows(rows)
+	})
+
+	s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout)}
+
+	var got *data.User
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = contextGetUser(r)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	s.authenticate(next).ServeHTTP(w, r)
+
+	if got == nil || got.ID != 3 {
+		t.Errorf("want user 3 got %v", got)
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		user        *data.User
+		permissions []string
+		wantStatus  int
+	}{
+		{"anonymous user", data.AnonymousUser, nil, http.StatusUnauthorized},
+		{"inactive user", &data.User{ID: 1}, []string{"movies:write"}, http.StatusForbidden},
+		{"missing permission", &data.User{ID: 1, Activated: true}, []string{"movies:read"}, http.StatusForbidden},
+		{"granted permission", &data.User{ID: 1, Activated: true}, []string{"movies:read", "movies:write"}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"code"})
+				for _, code := range tt.permissions {
+					rows.AddRow(code)
+				}
+				mock.ExpectQuery("FROM permissions").WithArgs(tt.user.ID).WillReturnRows(rows)
+			})
+
+			s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout)}
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}
+
+			r := contextSetUser(httptest.NewRequest(http.MethodPost, "/v1/movies", nil), tt.user)
+			w := httptest.NewRecorder()
+			s.requirePermission(data.PermissionMoviesWrite, next).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("want status %d got %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestRequestLogger_AttachRequestToServerErrorLog(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	s := &Server{logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		helper.ServerErrorResponse(w, r, errors.New("boom"))
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies?page=2", nil)
+	w := httptest.NewRecorder()
+	s.requestLogger(next).ServeHTTP(w, r)
+
+	requestID := w.Header().Get("X-Request-Id")
+	if requestID == "" {
+		t.Fatal("want X-Request-Id header")
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("can't decode log entry %q. Err:%v", buf.String(), err)
+	}
+
+	want := map[string]any{
+		"level":      "ERROR",
+		"msg":        "server error",
+		"error":      "boom",
+		"request_id": requestID,
+		"method":     http.MethodGet,
+		"uri":        "/v1/movies?page=2",
+	}
+
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("want log %s %v got %v", key, value, got[key])
+		}
+	}
+}
+
+func TestRecoverPanic_ReturnJSONServerError(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	s := &Server{logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	w := httptest.NewRecorder()
+	s.recoverPanic(s.requestLogger(next)).ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("want status %d got %d", http.StatusInternalServerError, w.Code)
+	}
+
+	if got := w.Header().Get("Connection"); got != "close" {
+		t.Errorf("want Connection close got %q", got)
+	}
+
+	want, err := helper.AnyToJSON(helper.Envelope{"error": "the server encountered a problem and could not process your request"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := w.Body.String(); want != got {
+		t.Errorf("want body %s got %s", want, got)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("can't decode log entry %q. Err:%v", buf.String(), err)
+	}
+
+	if got["error"] != "panic: boom" {
+		t.Errorf("want error panic: boom got %v", got["error"])
+	}
+
+	if got["request_id"] != w.Header().Get("X-Request-Id") {
+		t.Errorf("want request_id %s got %v", w.Header().Get("X-Request-Id"), got["request_id"])
+	}
+
+	if stack, _ := got["stack"].(string); stack == "" {
+		t.Error("want stack in log entry")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	t.Parallel()
+
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted forwarded for", "203.0.113.7:5000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"trusted forwarded for", "10.0.0.2:5000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"spoofed forwarded for", "10.0.0.2:5000", http.Header{"X-Forwarded-For": {"1.1.1.1, 198.51.100.1, 10.0.0.3"}}, "198.51.100.1"},
+		{"trusted real ip", "10.0.0.2:5000", http.Header{"X-Real-Ip": {"198.51.100.1"}}, "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for key, values := range tt.header {
+			r.Header[key] = values
+		}
+
+		if got := clientIP(r, trusted); got != tt.want {
+			t.Errorf("%s: want %s got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestRateLimit_Return429WithHeaders(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{limiter: ratelimit.NewMemory(1, 1)}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := s.rateLimit(next)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("want first request allowed got %d", w.Code)
+	}
+
+	if got := w.Header().Get("RateLimit-Limit"); got != "1" {
+		t.Errorf("want RateLimit-Limit 1 got %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("want status %d got %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("want Retry-After 1 got %q", got)
+	}
+
+	if got := w.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("want RateLimit-Remaining 0 got %q", got)
+	}
+}
+
+// failingLimiter is a ratelimit.RateLimiter whose store is down.
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(ctx context.Context, key string) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("connection refused")
+}
+
+func (failingLimiter) Sweep(ctx context.Context, idleTimeout time.Duration) error {
+	return errors.New("connection refused")
+}
+
+func TestRateLimit_LimiterFailure(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		failOpen bool
+		wantCode int
+	}{
+		{failOpen: true, wantCode: http.StatusOK},
+		{failOpen: false, wantCode: http.StatusServiceUnavailable},
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tt := range tests {
+		s := &Server{limiter: failingLimiter{}, limiterFailOpen: tt.failOpen}
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r = helper.ContextSetLogger(r, discardLogger)
+		w := httptest.NewRecorder()
+		s.rateLimit(next).ServeHTTP(w, r)
+
+		if w.Code != tt.wantCode {
+			t.Errorf("fail open %t: want status %d got %d", tt.failOpen, tt.wantCode, w.Code)
+		}
+	}
+}
+
+func TestEnableCORS(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{corsTrustedOrigins: []string{"https://pilem.example.com"}}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		preflight   bool
+		wantStatus  int
+		wantOrigin  string
+		wantMethods string
+	}{
+		{"no origin", http.MethodGet, "", false, http.StatusTeapot, "", ""},
+		{"untrusted origin", http.MethodGet, "https://evil.example.com", false, http.StatusTeapot, "", ""},
+		{"trusted origin", http.MethodGet, "https://pilem.example.com", false, http.StatusTeapot, "https://pilem.example.com", ""},
+		{"untrusted preflight", http.MethodOptions, "https://evil.example.com", true, http.StatusTeapot, "", ""},
+		{"trusted preflight", http.MethodOptions, "https://pilem.example.com", true, http.StatusOK, "https://pilem.example.com", "OPTIONS, GET, POST, PUT, PATCH, DELETE"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/v1/movies", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.preflight {
+			r.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+		}
+		w := httptest.NewRecorder()
+		s.enableCORS(next).ServeHTTP(w, r)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: want status %d got %d", tt.name, tt.wantStatus, w.Code)
+		}
+
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+			t.Errorf("%s: want Access-Control-Allow-Origin %q got %q", tt.name, tt.wantOrigin, got)
+		}
+
+		if got := w.Header().Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+			t.Errorf("%s: want Access-Control-Allow-Methods %q got %q", tt.name, tt.wantMethods, got)
+		}
+
+		if got := w.Header().Values("Vary"); !slices.Contains(got, "Origin") {
+			t.Errorf("%s: want Vary Origin got %v", tt.name, got)
+		}
+	}
+}
@@ -1,24 +1,151 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"log"
 	"net/http"
 	"pilem/helper"
 	"pilem/internal/data"
 	"pilem/internal/database"
+	"pilem/internal/validator"
+	"slices"
 	"strconv"
+	"strings"
 )
 
 func (s *Server) RegisterRoutes() http.Handler {
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", s.HelloWorldHandler)
 
-	mux.HandleFunc("/health", s.healthHandler)
+	// Anything that doesn't match a route below gets a JSON 404 instead of the
+	// plain-text reply of http.NotFound.
+	mux.HandleFunc("/", s.notFoundHandler)
 
-	return mux
+	handleMethods(mux, "/{$}", map[string]http.HandlerFunc{
+		http.MethodGet: s.HelloWorldHandler,
+	})
+	handleMethods(mux, "/v1/healthcheck", map[string]http.HandlerFunc{
+		http.MethodGet: s.healthcheckHandler,
+	})
+	handleMethods(mux, "/v1/readyz", map[string]http.HandlerFunc{
+		http.MethodGet: s.readinessHandler,
+	})
+
+	handleMethods(mux, "/debug/vars", map[string]http.HandlerFunc{
+		http.MethodGet: s.requirePermission(data.PermissionMetricsRead, s.debugVarsHandler),
+	})
+	handleMethods(mux, "/metrics", map[string]http.HandlerFunc{
+		http.MethodGet: s.requirePermission(data.PermissionMetricsRead, s.prometheusHandler),
+	})
+
+	handleMethods(mux, "/v1/movies", map[string]http.HandlerFunc{
+		http.MethodGet:  s.requirePermission(data.PermissionMoviesRead, s.ListMoviesHandler),
+		http.MethodPost: s.requirePermission(data.PermissionMoviesWrite, s.CreateMovieHandler),
+	})
+	handleMethodsNoFallback(mux, "/v1/movies/import", map[string]http.HandlerFunc{
+		http.MethodPost: s.requirePermission(data.PermissionMoviesWrite, s.ImportMoviesHandler),
+	})
+	handleMethods(mux, "/v1/movies/{id}", map[string]http.HandlerFunc{
+		http.MethodGet:    s.requirePermission(data.PermissionMoviesRead, s.GetMovieHandler),
+		http.MethodPatch:  s.requirePermission(data.PermissionMoviesWrite, s.UpdateMovieHandler),
+		http.MethodDelete: s.requirePermission(data.PermissionMoviesWrite, s.DeleteMovieHandler),
+	})
+
+	handleMethods(mux, "/v1/users", map[string]http.HandlerFunc{
+		http.MethodPost: s.RegisterUserHandler,
+	})
+	handleMethods(mux, "/v1/users/activated", map[string]http.HandlerFunc{
+		http.MethodPut: s.ActivateUserHandler,
+	})
+	handleMethods(mux, "/v1/users/password", map[string]http.HandlerFunc{
+		http.MethodPut: s.UpdateUserPasswordHandler,
+	})
+
+	handleMethods(mux, "/v1/tokens/authentication", map[string]http.HandlerFunc{
+		http.MethodPost: s.CreateAuthenticationTokenHandler,
+	})
+	handleMethods(mux, "/v1/tokens/password-reset", map[string]http.HandlerFunc{
+		http.MethodPost: s.CreatePasswordResetTokenHandler,
+	})
+
+	// recoverPanic comes first so it also catches the panics of the other
+	// middlewares.
+	return s.recoverPanic(s.recordMetrics(s.requestLogger(s.enableCORS(s.rateLimit(s.authenticate(mux))))))
+}
+
+// handleMethods registers every handler as "METHOD pattern" and adds a
+// method-less fallback on the same pattern, so a request with any other
+// method gets a JSON 405 with an Allow header instead of the mux default.
+func handleMethods(mux *http.ServeMux, pattern string, handlers map[string]http.HandlerFunc) {
+	allow := registerMethods(mux, pattern, handlers)
+
+	mux.HandleFunc(pattern, methodNotAllowedHandler(allow))
+}
+
+// standardMethods are the request methods of RFC 9110 and PATCH.
+var standardMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodOptions,
+	http.MethodTrace,
+}
+
+// handleMethodsNoFallback is handleMethods without the method-less fallback,
+// for "/v1/movies/import": that pattern would conflict with
+// "GET /v1/movies/{id}", since neither is more specific than the other, and
+// ServeMux panics on such conflicts. A 405 handler is registered for each of
+// the standardMethods without a handler instead, so that "DELETE
+// /v1/movies/import" doesn't reach the handlers of a movie named "import"
+// and answers with the Allow header of the import.
+func handleMethodsNoFallback(mux *http.ServeMux, pattern string, handlers map[string]http.HandlerFunc) {
+	allow := registerMethods(mux, pattern, handlers)
+
+	for _, method := range standardMethods {
+		// HEAD is matched by the GET pattern, whether a handler or the
+		// fallback.
+		if _, ok := handlers[method]; ok || method == http.MethodHead {
+			continue
+		}
+
+		mux.HandleFunc(method+" "+pattern, methodNotAllowedHandler(allow))
+	}
+}
+
+// registerMethods registers every handler as "METHOD pattern" and returns
+// the value of the Allow header for pattern.
+func registerMethods(mux *http.ServeMux, pattern string, handlers map[string]http.HandlerFunc) string {
+	allowed := make([]string, 0, len(handlers)+1)
+	for method, handler := range handlers {
+		mux.HandleFunc(method+" "+pattern, handler)
+
+		allowed = append(allowed, method)
+		// A GET pattern also matches HEAD requests.
+		if method == http.MethodGet {
+			allowed = append(allowed, http.MethodHead)
+		}
+	}
+	slices.Sort(allowed)
+
+	return strings.Join(allowed, ", ")
+}
+
+// methodNotAllowedHandler returns a handler writing a JSON 405 with the
+// Allow header set to allow.
+func methodNotAllowedHandler(allow string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		helper.MethodNotAllowedResponse(w, r)
+	}
+}
+
+func (s *Server) notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	helper.NotFoundResponse(w, r, nil)
 }
 
 func (s *Server) HelloWorldHandler(w http.ResponseWriter, r *http.Request) {
@@ -27,22 +154,13 @@ func (s *Server) HelloWorldHandler(w http.ResponseWriter, r *http.Request) {
 
 	jsonResp, err := json.Marshal(resp)
 	if err != nil {
-		log.Fatalf("error handling JSON marshal. Err: %v", err)
+		helper.ServerErrorResponse(w, r, err)
+		return
 	}
 
 	_, _ = w.Write(jsonResp)
 }
 
-func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
-	// jsonResp, err := json.Marshal(s.db.Health())
-
-	// if err != nil {
-	// 	log.Fatalf("error handling JSON marshal. Err: %v", err)
-	// }
-
-	// _, _ = w.Write(jsonResp)
-}
-
 func (s *Server) CreateMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Title   string       `json:"title"`
@@ -64,9 +182,16 @@ func (s *Server) CreateMovieHandler(w http.ResponseWriter, r *http.Request) {
 		Genres:  input.Genres,
 	}
 
-	err = s.db.Movies.Insert(movie)
+	v := validator.New()
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		helper.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = s.db.Movies.Insert(r.Context(), movie)
 	if err != nil {
-		helper.ServerErrorResponse(w, r, err)
+		movieWriteErrorResponse(w, r, err)
 		return
 	}
 
@@ -85,13 +210,13 @@ func (s *Server) GetMovieHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	movie, err := s.db.Movies.Get(id)
+	movie, err := s.db.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, database.ErrRecordNotFound):
 			helper.NotFoundResponse(w, r, err)
 		default:
-			helper.ServerErrorResponse(w, r, err)
+			databaseErrorResponse(w, r, err)
 		}
 		return
 	}
@@ -103,6 +228,62 @@ func (s *Server) GetMovieHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+func (s *Server) ListMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title  string
+		Query  string
+		Genres []string
+		data.Filters
+	}
+
+	qs := r.URL.Query()
+
+	input.Title = helper.ReadString(qs, "title", "")
+	input.Query = helper.ReadString(qs, "q", "")
+	input.Genres = helper.ReadCSV(qs, "genres", []string{})
+
+	v := validator.New()
+
+	input.Filters.Page = helper.ReadInt(qs, "page", 1, v)
+	input.Filters.PageSize = helper.ReadInt(qs, "page_size", 20, v)
+	input.Filters.Sort = helper.ReadString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+	// A full-text search is ordered by relevance unless asked otherwise.
+	if input.Query != "" {
+		input.Filters.Sort = helper.ReadString(qs, "sort", "-relevance")
+		input.Filters.SortSafelist = append(input.Filters.SortSafelist, "relevance", "-relevance")
+	}
+
+	v.Check(input.Title == "" || input.Query == "", "q", "must not be combined with title")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		helper.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var (
+		movies   any
+		metadata data.Metadata
+		err      error
+	)
+
+	if input.Query != "" {
+		movies, metadata, err = s.db.Movies.Search(r.Context(), input.Query, input.Genres, input.Filters)
+	} else {
+		movies, metadata, err = s.db.Movies.List(r.Context(), input.Title, input.Genres, input.Filters)
+	}
+	if err != nil {
+		databaseErrorResponse(w, r, err)
+		return
+	}
+
+	err = helper.WriteJSON(w, http.StatusOK, helper.Envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		helper.ServerErrorResponse(w, r, err)
+	}
+}
+
 func (s *Server) DeleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	idString := r.PathValue("id")
 	id, err := strconv.ParseInt(idString, 10, 32)
@@ -111,13 +292,13 @@ func (s *Server) DeleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.db.Movies.Delete(id)
+	err = s.db.Movies.Delete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, database.ErrRecordNotFound):
 			helper.NotFoundResponse(w, r, err)
 		default:
-			helper.ServerErrorResponse(w, r, err)
+			databaseErrorResponse(w, r, err)
 		}
 		return
 	}
@@ -135,13 +316,13 @@ func (s *Server) UpdateMovieHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	movie, err := s.db.Movies.Get(id)
+	movie, err := s.db.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, database.ErrRecordNotFound):
 			helper.NotFoundResponse(w, r, err)
 		default:
-			helper.ServerErrorResponse(w, r, err)
+			databaseErrorResponse(w, r, err)
 		}
 		return
 	}
@@ -173,13 +354,20 @@ func (s *Server) UpdateMovieHandler(w http.ResponseWriter, r *http.Request) {
 		movie.Genres = input.Genres
 	}
 
-	err = s.db.Movies.Update(movie)
+	v := validator.New()
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		helper.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = s.db.Movies.Update(r.Context(), movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, database.ErrEditConflict):
 			helper.EditConflictResponse(w, r, err)
 		default:
-			helper.ServerErrorResponse(w, r, err)
+			movieWriteErrorResponse(w, r, err)
 		}
 		return
 	}
@@ -189,3 +377,47 @@ func (s *Server) UpdateMovieHandler(w http.ResponseWriter, r *http.Request) {
 		helper.ServerErrorResponse(w, r, err)
 	}
 }
+
+// movieConstraintErrors maps the CHECK constraints of the movies table to the
+// field error reported to the client when one is violated.
+var movieConstraintErrors = map[string]map[string]string{
+	"movies_runtime_check": {"runtime": "must not be negative"},
+	"movies_year_check":    {"year": "must be between 1888 and the current year"},
+	"genres_length_check":  {"genres": "must contain between 1 and 5 genres"},
+}
+
+// movieWriteErrorResponse writes the response for an error returned by
+// inserting or updating a movie.
+func movieWriteErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var queryErr *database.QueryError
+
+	switch {
+	case errors.Is(err, database.ErrCheckViolation) && errors.As(err, &queryErr):
+		fieldErrors, ok := movieConstraintErrors[queryErr.Constraint]
+		if !ok {
+			fieldErrors = map[string]string{"movie": "violates constraint " + queryErr.Constraint}
+		}
+		helper.FailedValidationResponse(w, r, fieldErrors)
+	case errors.Is(err, database.ErrUniqueViolation) && errors.As(err, &queryErr):
+		helper.ConflictResponse(w, r, "a movie conflicting with "+queryErr.Constraint+" already exists")
+	case errors.Is(err, database.ErrSerializationFailure):
+		helper.ServiceUnavailableResponse(w, r, err)
+	default:
+		databaseErrorResponse(w, r, err)
+	}
+}
+
+// databaseErrorResponse writes the response for an unexpected error returned
+// by a model. A query cancelled because the client went away gets no body,
+// and one that ran out of time gets a 503 rather than a 500.
+func databaseErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case r.Context().Err() != nil:
+		helper.ClientClosedRequestResponse(w, r, err)
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, database.ErrQueryCanceled):
+		helper.ContextGetLogger(r).Warn("database query timeout", "error", err)
+		helper.ServiceUnavailableResponse(w, r, err)
+	default:
+		helper.ServerErrorResponse(w, r, err)
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -49,7 +50,7 @@ func TestCreateMovieHandler_ReturnMovieWhenPost(t *testing.T) {
 	})
 
 	s := &Server{
-		db: database.NewModels(db),
+		db: database.NewModels(db, database.DefaultQueryTimeout),
 	}
 	server := httptest.NewServer(http.HandlerFunc(s.CreateMovieHandler))
 	defer server.Close()
@@ -117,7 +118,7 @@ func TestGetMovieHandler_ReturnMovieByID(t *testing.T) {
 	})
 
 	s := &Server{
-		db: database.NewModels(db),
+		db: database.NewModels(db, database.DefaultQueryTimeout),
 	}
 
 	r := httptest.NewRequest(http.MethodGet, "/movies/2", nil)
@@ -151,8 +152,8 @@ func TestDeleteMovieHandler_ReturnNothing(t *testing.T) {
 		mock.ExpectExec("DELETE FROM movies WHERE id").WithArgs(id).WillReturnResult(sqlmock.NewResult(0, id))
 	})
 
-	m := database.NewModels(db)
-	err := m.Movies.Delete(id)
+	m := database.NewModels(db, database.DefaultQueryTimeout)
+	err := m.Movies.Delete(context.Background(), id)
 	if err != nil {
 		t.Fatalf("Can't delete movie id: %d, Err: %v", id, err)
 	}
@@ -183,7 +184,7 @@ func TestUpdateMovieHandler_ReturnMovie(t *testing.T) {
 			WillReturnRows(rows)
 	})
 
-	m := database.NewModels(db)
+	m := database.NewModels(db, database.DefaultQueryTimeout)
 	s := Server{db: m}
 
 	setupJson, err := helper.AnyToJSON(want)
@@ -214,3 +215,292 @@ func TestUpdateMovieHandler_ReturnMovie(t *testing.T) {
 	}
 
 }
+
+func TestRegisterRoutes_ReturnJSONNotFoundForUnknownPath(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{logger: discardLogger}
+	r := httptest.NewRequest(http.MethodGet, "/v1/unknown", nil)
+	w := httptest.NewRecorder()
+	s.RegisterRoutes().ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("want status %d got %d", http.StatusNotFound, w.Code)
+	}
+
+	want, err := helper.AnyToJSON(helper.Envelope{"error": "the requested resource could not be found"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := w.Body.String(); want != got {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestRegisterRoutes_ReturnJSONMethodNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		method    string
+		path      string
+		wantAllow string
+	}{
+		{http.MethodPut, "/v1/movies", "GET, HEAD, POST"},
+		{http.MethodPut, "/v1/movies/1", "DELETE, GET, HEAD, PATCH"},
+		{http.MethodGet, "/v1/movies/import", "POST"},
+		{http.MethodDelete, "/v1/movies/import", "POST"},
+		{http.MethodHead, "/v1/movies/import", "POST"},
+		{http.MethodOptions, "/v1/movies/import", "POST"},
+		{http.MethodTrace, "/v1/movies/import", "POST"},
+	}
+
+	s := &Server{logger: discardLogger}
+	handler := s.RegisterRoutes()
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: want status %d got %d", tt.method, tt.path, http.StatusMethodNotAllowed, w.Code)
+		}
+
+		if got := w.Header().Get("Allow"); got != tt.wantAllow {
+			t.Errorf("%s %s: want Allow %q got %q", tt.method, tt.path, tt.wantAllow, got)
+		}
+
+		want, err := helper.AnyToJSON(helper.Envelope{"error": "the " + tt.method + " method is not supported for this resource"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := w.Body.String(); want != got {
+			t.Error(cmp.Diff(want, got))
+		}
+	}
+}
+
+func TestListMoviesHandler_ReturnMoviesAndMetadata(t *testing.T) {
+	t.Parallel()
+
+	want := data.Movie{
+		ID:      1,
+		Title:   "overlord",
+		Year:    2024,
+		Runtime: 135,
+		Genres:  []string{"Action", "Fantasy"},
+		Version: 1,
+	}
+
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		rows := sqlmock.NewRows([]string{"count", "id", "created_at", "title", "year", "runtime", "genres", "version"}).
+			AddRow(1, want.ID, want.CreatedAt, want.Title, want.Year, want.Runtime, pq.Array(want.Genres), want.Version)
+		mock.ExpectQuery("ORDER BY title ASC").
+			WithArgs("over", pq.Array([]string{"Action", "Fantasy"}), 10, 0).
+			WillReturnRows(rows)
+	})
+
+	s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout)}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies?title=over&genres=Action,Fantasy&page_size=10&sort=title", nil)
+	w := httptest.NewRecorder()
+	s.ListMoviesHandler(w, r)
+
+	wantStr, err := helper.AnyToJSON(helper.Envelope{
+		"movies":   []data.Movie{want},
+		"metadata": data.Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := w.Body.String(); wantStr != got {
+		t.Error(cmp.Diff(wantStr, got))
+	}
+}
+
+func TestListMoviesHandler_RejectUnknownSort(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies?sort=created_at", nil)
+	w := httptest.NewRecorder()
+	s.ListMoviesHandler(w, r)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("want status %d got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+}
+
+func TestCreateMovieHandler_ReturnValidationErrors(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{}
+
+	input := `{"title":"","year":1700,"runtime":-5,"genres":["Action","Action"]}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(input))
+	w := httptest.NewRecorder()
+	s.CreateMovieHandler(w, r)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("want status %d got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	want, err := helper.AnyToJSON(helper.Envelope{"error": map[string]string{
+		"title":   "must be provided",
+		"year":    "must be 1888 or later",
+		"runtime": "must be a positive integer",
+		"genres":  "must not contain duplicate values",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := w.Body.String(); want != got {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestCreateMovieHandler_ReturnConstraintViolationAsValidationError(t *testing.T) {
+	t.Parallel()
+
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("").WillReturnError(&pq.Error{Code: "23514", Constraint: "movies_runtime_check"})
+	})
+
+	s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout)}
+
+	input := `{"title":"overlord","year":2024,"runtime":135,"genres":["Action"]}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(input))
+	w := httptest.NewRecorder()
+	s.CreateMovieHandler(w, r)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("want status %d got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	want, err := helper.AnyToJSON(helper.Envelope{"error": map[string]string{"runtime": "must not be negative"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := w.Body.String(); want != got {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestListMoviesHandler_SearchReturnRank(t *testing.T) {
+	t.Parallel()
+
+	want := data.MovieSearchResult{
+		Movie: &data.Movie{ID: 1, Title: "overlord", Year: 2024, Runtime: 135, Genres: []string{"Action"}, Version: 1},
+		Rank:  0.5,
+	}
+
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		rows := sqlmock.NewRows([]string{"count", "id", "created_at", "title", "year", "runtime", "genres", "version", "relevance"}).
+			AddRow(1, want.ID, want.CreatedAt, want.Title, want.Year, want.Runtime, pq.Array(want.Genres), want.Version, want.Rank)
+		mock.ExpectQuery("ORDER BY relevance DESC").
+			WithArgs("overlord", pq.Array([]string{}), 20, 0).
+			WillReturnRows(rows)
+	})
+
+	s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout)}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies?q=overlord", nil)
+	w := httptest.NewRecorder()
+	s.ListMoviesHandler(w, r)
+
+	wantStr, err := helper.AnyToJSON(helper.Envelope{
+		"movies":   []data.MovieSearchResult{want},
+		"metadata": data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := w.Body.String(); wantStr != got {
+		t.Error(cmp.Diff(wantStr, got))
+	}
+}
+
+func TestListMoviesHandler_RejectRelevanceSortWithoutQuery(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies?sort=-relevance", nil)
+	w := httptest.NewRecorder()
+	s.ListMoviesHandler(w, r)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("want status %d got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+}
+
+func TestGetMovieHandler_DatabaseCancellation(t *testing.T) {
+	t.Parallel()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		err      error
+		wantCode int
+	}{
+		{name: "query timeout", ctx: context.Background(), err: context.DeadlineExceeded, wantCode: http.StatusServiceUnavailable},
+		{name: "query canceled by the server", ctx: context.Background(), err: &database.QueryError{Code: "57014"}, wantCode: http.StatusServiceUnavailable},
+		{name: "client went away", ctx: canceled, err: context.Canceled, wantCode: helper.StatusClientClosedRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM movies WHERE id").WillReturnError(tt.err)
+			})
+
+			s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout)}
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil).WithContext(tt.ctx)
+			r.SetPathValue("id", "1")
+			w := httptest.NewRecorder()
+
+			s.GetMovieHandler(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("want status %d got %d", tt.wantCode, w.Code)
+			}
+		})
+	}
+}
+
+func TestMovieHandlers_MemoryStore(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{db: database.Models{Movies: database.NewMemoryMovieStore()}}
+
+	body := `{"title": "Overlord", "year": 2018, "runtime": "110 mins", "genres": ["Action", "War"]}`
+	w := httptest.NewRecorder()
+	s.CreateMovieHandler(w, httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(body)))
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("want status %d got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil)
+	r.SetPathValue("id", "1")
+	w = httptest.NewRecorder()
+	s.GetMovieHandler(w, r)
+
+	want := `{"movie":{"id":1,"title":"Overlord","year":2018,"runtime":"110 mins","genres":["Action","War"],"version":1}}`
+	if got := strings.TrimSpace(w.Body.String()); got != want {
+		t.Errorf("want %s got %s", want, got)
+	}
+}
@@ -1,40 +1,293 @@
 package server
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
-	"os"
-	"strconv"
+	"net/netip"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 
 	"pilem/internal/database"
+	"pilem/internal/mailer"
+	"pilem/internal/ratelimit"
+	"pilem/migrations"
 )
 
+// Config holds the settings of the HTTP server.
+type Config struct {
+	Port int
+	// Env is the name of the environment the server runs in, like
+	// "development" or "production".
+	Env string
+	// Version is the version of the running binary, reported by the
+	// metrics.
+	Version string
+	// ShutdownTimeout bounds how long in-flight requests get to complete
+	// once a shutdown signal is received.
+	ShutdownTimeout time.Duration
+	// QueryTimeout bounds every database query made for a request.
+	QueryTimeout time.Duration
+	// DBDriver is the driver of the database: "postgres" or "sqlite".
+	DBDriver string
+	// Store is where the data is kept: "postgres" for the database, whatever
+	// its driver, or "memory" for stores lost on restart, for tests and
+	// demos, which need no database.
+	Store string
+
+	Limiter LimiterConfig
+
+	// CORSTrustedOrigins are the origins allowed to make cross-origin
+	// requests, like "https://www.example.com".
+	CORSTrustedOrigins []string
+}
+
+// LimiterConfig holds the settings of the per-client rate limiter.
+type LimiterConfig struct {
+	Enabled bool
+	// Backend is where the limits are kept: "memory" for this instance only,
+	// or "postgres" to share them between every instance using the database.
+	Backend string
+	// RPS is the average number of requests per second allowed per client.
+	RPS   float64
+	Burst int
+	// FailOpen lets the requests through when the limiter fails, like when
+	// the database is down, instead of rejecting them with a 503.
+	FailOpen bool
+	// TrustedProxies are the networks whose X-Forwarded-For and X-Real-IP
+	// headers are trusted to carry the client IP.
+	TrustedProxies []netip.Prefix
+}
+
 type Server struct {
-	port int
+	port            int
+	env             string
+	version         string
+	shutdownTimeout time.Duration
+
+	logger *slog.Logger
+
+	limiter         ratelimit.RateLimiter
+	limiterFailOpen bool
+	trustedProxies  []netip.Prefix
+
+	corsTrustedOrigins []string
 
 	db database.Models
+	// sqlDB is the pool behind db, kept for its stats. It is nil with the
+	// memory store, along with health and migrations.
+	sqlDB *sql.DB
+	// health reports on sqlDB for the readiness check.
+	health database.Service
+	// migrations are the migrations of sqlDB, checked by the readiness check.
+	migrations fs.FS
+
+	mailer mailer.Mailer
+
+	metrics metrics
+
+	// wg tracks the goroutines started with background, so shutdown can wait
+	// for them.
+	wg sync.WaitGroup
+
+	// stopping is given to the background tasks and cancelled when the
+	// shutdown starts, so they stop waiting on retries. It is created by
+	// stoppingContext.
+	stopping     context.Context
+	stop         context.CancelFunc
+	stoppingOnce sync.Once
+}
+
+func NewServer(cfg Config, logger *slog.Logger, db *sql.DB, mailer mailer.Mailer) *Server {
+	s := &Server{
+		port:            cfg.Port,
+		env:             cfg.Env,
+		version:         cfg.Version,
+		shutdownTimeout: cfg.ShutdownTimeout,
+
+		logger: logger,
+
+		limiterFailOpen: cfg.Limiter.FailOpen,
+		trustedProxies:  cfg.Limiter.TrustedProxies,
+
+		corsTrustedOrigins: cfg.CORSTrustedOrigins,
+
+		sqlDB: db,
+
+		mailer: mailer,
+	}
+
+	switch {
+	case cfg.Store == "memory":
+		s.db = database.NewMemoryModels()
+	case cfg.DBDriver == "sqlite":
+		s.db = database.NewSQLiteModels(db, cfg.QueryTimeout)
+	default:
+		s.db = database.NewModels(db, cfg.QueryTimeout)
+	}
+
+	// Without a database, like with the memory store, there is nothing to
+	// check but the process.
+	if db != nil {
+		s.health = database.NewService(db)
+		s.migrations = migrations.For(cfg.DBDriver)
+	}
+
+	// A nil limiter disables rate limiting.
+	if cfg.Limiter.Enabled {
+		switch cfg.Limiter.Backend {
+		case "postgres":
+			s.limiter = ratelimit.NewPostgres(db, cfg.Limiter.RPS, cfg.Limiter.Burst, cmp.Or(cfg.QueryTimeout, database.DefaultQueryTimeout))
+		default:
+			s.limiter = ratelimit.NewMemory(cfg.Limiter.RPS, cfg.Limiter.Burst)
+		}
+	}
+
+	return s
 }
 
-func NewServer(db *sql.DB) *http.Server {
-	port, _ := strconv.Atoi(os.Getenv("PORT"))
-	NewServer := &Server{
-		port: port,
+// Serve listens on the configured port and serves requests until the process
+// receives SIGINT or SIGTERM. It then shuts the server down gracefully and
+// waits for the background tasks to finish. It returns nil on a clean
+// shutdown.
+func (s *Server) Serve() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-		db: database.NewModels(db),
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", s.port))
+	if err != nil {
+		return err
 	}
 
+	return s.serve(ctx, l)
+}
+
+// serve serves requests on l until ctx is done.
+func (s *Server) serve(ctx context.Context, l net.Listener) error {
 	// Declare Server config
-	server := &http.Server{
-		Addr:         fmt.Sprintf("127.0.0.1:%d", NewServer.port),
-		Handler:      NewServer.RegisterRoutes(),
+	srv := &http.Server{
+		Handler:      s.RegisterRoutes(),
+		ErrorLog:     slog.NewLogLogger(s.logger.Handler(), slog.LevelWarn),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
 
-	return server
+	if s.limiter != nil {
+		s.background(func(ctx context.Context) {
+			s.sweepLimiter(ctx, time.Minute, 3*time.Minute)
+		})
+	}
+
+	shutdownError := make(chan error)
+
+	go func() {
+		<-ctx.Done()
+
+		s.logger.Info("shutting down server")
+
+		s.stoppingContext()
+		s.stop()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+		defer cancel()
+
+		err := srv.Shutdown(shutdownCtx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		s.logger.Info("completing background tasks")
+
+		// The background tasks get what is left of the shutdown timeout, so
+		// a stuck one can't keep the process from exiting.
+		tasksDone := make(chan struct{})
+		go func() {
+			s.wg.Wait()
+			close(tasksDone)
+		}()
+
+		select {
+		case <-tasksDone:
+			shutdownError <- nil
+		case <-shutdownCtx.Done():
+			shutdownError <- fmt.Errorf("background tasks still running after %s: %w", s.shutdownTimeout, shutdownCtx.Err())
+		}
+	}()
+
+	s.logger.Info("starting server", "addr", l.Addr().String())
+
+	err := srv.Serve(l)
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("stopped server")
+
+	return nil
+}
+
+// sweepLimiter makes the rate limiter forget the clients idle for idleTimeout
+// every interval, until ctx is done.
+func (s *Server) sweepLimiter(ctx context.Context, interval, idleTimeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.limiter.Sweep(ctx, idleTimeout)
+			if err != nil && ctx.Err() == nil {
+				s.logger.Error("can't sweep rate limiter", "error", err)
+			}
+		}
+	}
+}
+
+// stoppingContext returns the context cancelled when the shutdown starts.
+func (s *Server) stoppingContext() context.Context {
+	s.stoppingOnce.Do(func() {
+		s.stopping, s.stop = context.WithCancel(context.Background())
+	})
+
+	return s.stopping
+}
+
+// background runs fn in a goroutine that shutdown waits for, with a context
+// cancelled when the shutdown starts. A panic in fn is recovered and logged
+// instead of crashing the process.
+func (s *Server) background(fn func(ctx context.Context)) {
+	ctx := s.stoppingContext()
+
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				s.logger.Error("panic in background task", "error", err)
+			}
+		}()
+
+		fn(ctx)
+	}()
 }
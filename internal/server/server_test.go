@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// discardLogger is the logger of test servers whose logs aren't asserted.
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestServe_WaitForBackgroundTasksOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{shutdownTimeout: time.Second, logger: discardLogger}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- s.serve(ctx, l)
+	}()
+
+	resp, err := http.Get("http://" + l.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("error making request to server. Err: %v", err)
+	}
+	resp.Body.Close()
+
+	var finished atomic.Bool
+	s.background(func(context.Context) {
+		time.Sleep(100 * time.Millisecond)
+		finished.Store(true)
+	})
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatalf("want clean shutdown got %v", err)
+	}
+
+	if !finished.Load() {
+		t.Error("serve returned before the background task finished")
+	}
+}
+
+func TestServe_StopWaitingForBackgroundTasksAfterShutdownTimeout(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{shutdownTimeout: 50 * time.Millisecond, logger: discardLogger}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- s.serve(ctx, l)
+	}()
+
+	stuck := make(chan struct{})
+	t.Cleanup(func() { close(stuck) })
+
+	s.background(func(context.Context) {
+		<-stuck
+	})
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("want context.DeadlineExceeded got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve kept waiting for a stuck background task")
+	}
+}
+
+func TestServe_CancelBackgroundContextOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{shutdownTimeout: 5 * time.Second, logger: discardLogger}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- s.serve(ctx, l)
+	}()
+
+	s.background(func(ctx context.Context) {
+		<-ctx.Done()
+	})
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatalf("want clean shutdown got %v", err)
+	}
+}
+
+func TestBackground_RecoverPanic(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{logger: discardLogger}
+	s.background(func(context.Context) {
+		panic("boom")
+	})
+
+	// Wait returns only if the panic was recovered and Done was called.
+	s.wg.Wait()
+}
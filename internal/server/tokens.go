@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"pilem/helper"
+	"pilem/internal/data"
+	"pilem/internal/database"
+	"pilem/internal/validator"
+	"time"
+)
+
+func (s *Server) CreateAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := helper.ReadJSON(w, r, &input)
+	if err != nil {
+		helper.BadRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	data.ValidatePasswordPlaintext(v, input.Password)
+
+	if !v.Valid() {
+		helper.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := s.db.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrRecordNotFound):
+			helper.InvalidCredentialsResponse(w, r)
+		default:
+			databaseErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		helper.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		helper.InvalidCredentialsResponse(w, r)
+		return
+	}
+
+	token, err := s.db.Tokens.New(r.Context(), user.ID, 24*time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		databaseErrorResponse(w, r, err)
+		return
+	}
+
+	err = helper.WriteJSON(w, http.StatusCreated, helper.Envelope{"authentication_token": token}, nil)
+	if err != nil {
+		helper.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (s *Server) CreatePasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := helper.ReadJSON(w, r, &input)
+	if err != nil {
+		helper.BadRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		helper.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := s.db.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			helper.FailedValidationResponse(w, r, v.Errors)
+		default:
+			databaseErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.Activated {
+		v.AddError("email", "user account must be activated")
+		helper.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := s.db.Tokens.New(r.Context(), user.ID, 45*time.Minute, data.ScopePasswordReset)
+	if err != nil {
+		databaseErrorResponse(w, r, err)
+		return
+	}
+
+	s.background(func(ctx context.Context) {
+		data := map[string]any{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := s.mailer.Send(ctx, user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			helper.ContextGetLogger(r).Error("can't send password reset email", "error", err)
+		}
+	})
+
+	env := helper.Envelope{"message": "an email will be sent to you containing password reset instructions"}
+
+	err = helper.WriteJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		helper.ServerErrorResponse(w, r, err)
+	}
+}
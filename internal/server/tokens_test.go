@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"pilem/helper"
+	"pilem/internal/data"
+	"pilem/internal/database"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCreateAuthenticationTokenHandler(t *testing.T) {
+	t.Parallel()
+
+	var password data.Password
+	if err := password.Set("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		password   string
+		wantStatus int
+	}{
+		{"valid credentials", "pa55word1234", http.StatusCreated},
+		{"wrong password", "wrongpa55word", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "created_at", "name", "email", "password_hash", "activated", "version"}).
+					AddRow(1, time.Now(), "alice", "alice@example.com", password.Hash, true, 1)
+				mock.ExpectQuery("FROM users").WithArgs("alice@example.com").WillReturnRows(rows)
+				mock.ExpectExec("INSERT INTO tokens").
+					WithArgs(sqlmock.AnyArg(), 1, sqlmock.AnyArg(), data.ScopeAuthentication).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			})
+
+			s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout)}
+
+			input := `{"email":"alice@example.com","password":"` + tt.password + `"}`
+			r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", strings.NewReader(input))
+			w := httptest.NewRecorder()
+			s.CreateAuthenticationTokenHandler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("want status %d got %d", tt.wantStatus, w.Code)
+			}
+
+			if tt.wantStatus != http.StatusCreated {
+				return
+			}
+
+			var got struct {
+				Token data.Token `json:"authentication_token"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got.Token.Plaintext) != 26 {
+				t.Errorf("want 26 byte token got %q", got.Token.Plaintext)
+			}
+		})
+	}
+}
+
+func TestCreatePasswordResetTokenHandler_SendResetEmail(t *testing.T) {
+	t.Parallel()
+
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		rows := sqlmock.NewRows([]string{"id", "created_at", "name", "email", "password_hash", "activated", "version"}).
+			AddRow(1, time.Now(), "alice", "alice@example.com", []byte("hash"), true, 1)
+		mock.ExpectQuery("FROM users").WithArgs("alice@example.com").WillReturnRows(rows)
+		mock.ExpectExec("INSERT INTO tokens").
+			WithArgs(sqlmock.AnyArg(), 1, sqlmock.AnyArg(), data.ScopePasswordReset).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	})
+
+	mailer := newFakeMailer()
+	s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout), mailer: mailer, logger: discardLogger}
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/tokens/password-reset", strings.NewReader(`{"email":"alice@example.com"}`))
+	w := httptest.NewRecorder()
+	s.CreatePasswordResetTokenHandler(w, r)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("want status %d got %d", http.StatusAccepted, w.Code)
+	}
+
+	email := <-mailer.sent
+	if email.templateFile != "token_password_reset.tmpl" {
+		t.Errorf("want password reset email got %q", email.templateFile)
+	}
+
+	token, _ := email.data.(map[string]any)["passwordResetToken"].(string)
+	if len(token) != 26 {
+		t.Errorf("want 26 byte token in email got %q", token)
+	}
+}
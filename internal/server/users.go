@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"pilem/helper"
+	"pilem/internal/data"
+	"pilem/internal/database"
+	"pilem/internal/validator"
+	"time"
+)
+
+func (s *Server) RegisterUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := helper.ReadJSON(w, r, &input)
+	if err != nil {
+		helper.BadRequestResponse(w, r, err)
+		return
+	}
+
+	user := &data.User{
+		Name:      input.Name,
+		Email:     input.Email,
+		Password:  data.Password{Plaintext: &input.Password},
+		Activated: false,
+	}
+
+	v := validator.New()
+
+	// Validate before hashing, which is slow on purpose and fails on
+	// passwords longer than bcrypt allows.
+	if data.ValidateUser(v, user); !v.Valid() {
+		helper.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		helper.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	var token *data.Token
+
+	// The user must not exist without its permissions or activation token.
+	err = s.db.WithTx(r.Context(), nil, func(tx database.Models) error {
+		err := tx.Users.Insert(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		// New users can browse the catalog but not change it.
+		err = tx.Permissions.AddForUser(r.Context(), user.ID, data.PermissionMoviesRead)
+		if err != nil {
+			return err
+		}
+
+		token, err = tx.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			helper.FailedValidationResponse(w, r, v.Errors)
+		default:
+			databaseErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Sending the email can take a while, so don't make the client wait for
+	// it.
+	s.background(func(ctx context.Context) {
+		data := map[string]any{
+			"activationToken": token.Plaintext,
+			"userID":          user.ID,
+		}
+
+		err := s.mailer.Send(ctx, user.Email, "user_welcome.tmpl", data)
+		if err != nil {
+			helper.ContextGetLogger(r).Error("can't send welcome email", "error", err)
+		}
+	})
+
+	// 202 Accepted, since the activation email is still being sent.
+	err = helper.WriteJSON(w, http.StatusAccepted, helper.Envelope{"user": user}, nil)
+	if err != nil {
+		helper.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (s *Server) ActivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := helper.ReadJSON(w, r, &input)
+	if err != nil {
+		helper.BadRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		helper.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := s.db.Users.GetForToken(r.Context(), data.ScopeActivation, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired activation token")
+			helper.FailedValidationResponse(w, r, v.Errors)
+		default:
+			databaseErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user.Activated = true
+
+	// Delete the tokens along with the update, so they can't be used twice.
+	version := user.Version
+	err = s.db.WithTx(r.Context(), nil, func(tx database.Models) error {
+		// Update bumps the version, so a retried transaction would conflict
+		// with the attempt that was rolled back.
+		user.Version = version
+
+		err := tx.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrEditConflict):
+			helper.EditConflictResponse(w, r, err)
+		default:
+			databaseErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = helper.WriteJSON(w, http.StatusOK, helper.Envelope{"user": user}, nil)
+	if err != nil {
+		helper.ServerErrorResponse(w, r, err)
+	}
+}
+
+func (s *Server) UpdateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := helper.ReadJSON(w, r, &input)
+	if err != nil {
+		helper.BadRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		helper.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := s.db.Users.GetForToken(r.Context(), data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			helper.FailedValidationResponse(w, r, v.Errors)
+		default:
+			databaseErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		helper.ServerErrorResponse(w, r, err)
+		return
+	}
+
+	// Delete the tokens along with the update, so they can't be used twice.
+	version := user.Version
+	err = s.db.WithTx(r.Context(), nil, func(tx database.Models) error {
+		// Update bumps the version, so a retried transaction would conflict
+		// with the attempt that was rolled back.
+		user.Version = version
+
+		err := tx.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Tokens.DeleteAllForUser(r.Context(), data.ScopePasswordReset, user.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrEditConflict):
+			helper.EditConflictResponse(w, r, err)
+		default:
+			databaseErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = helper.WriteJSON(w, http.StatusOK, helper.Envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		helper.ServerErrorResponse(w, r, err)
+	}
+}
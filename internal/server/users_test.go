@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"pilem/helper"
+	"pilem/internal/data"
+	"pilem/internal/database"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/go-cmp/cmp"
+	"github.com/lib/pq"
+)
+
+// fakeMailer records the emails it is asked to send on a channel.
+type fakeMailer struct {
+	sent chan fakeEmail
+}
+
+type fakeEmail struct {
+	recipient    string
+	templateFile string
+	data         any
+}
+
+func newFakeMailer() *fakeMailer {
+	return &fakeMailer{sent: make(chan fakeEmail, 1)}
+}
+
+func (m *fakeMailer) Send(ctx context.Context, recipient, templateFile string, data any) error {
+	m.sent <- fakeEmail{recipient, templateFile, data}
+	return nil
+}
+
+func TestRegisterUserHandler_ReturnUserWithoutPassword(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		rows := sqlmock.NewRows([]string{"id", "created_at", "version"}).AddRow(1, createdAt, 1)
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO users").WillReturnRows(rows)
+		mock.ExpectExec("INSERT INTO users_permissions").
+			WithArgs(1, pq.Array([]string{"movies:read"})).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO tokens").
+			WithArgs(sqlmock.AnyArg(), 1, sqlmock.AnyArg(), data.ScopeActivation).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	})
+
+	mailer := newFakeMailer()
+	s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout), mailer: mailer, logger: discardLogger}
+
+	input := `{"name":"alice","email":"alice@example.com","password":"pa55word1234"}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(input))
+	w := httptest.NewRecorder()
+	s.RegisterUserHandler(w, r)
+
+	if w.Code != http.StatusAccepted {
+		t.Errorf("want status %d got %d", http.StatusAccepted, w.Code)
+	}
+
+	want := `{"user":{"id":1,"created_at":"2024-07-01T00:00:00Z","name":"alice","email":"alice@example.com","activated":false}}`
+	if got := w.Body.String(); want != got {
+		t.Error(cmp.Diff(want, got))
+	}
+
+	email := <-mailer.sent
+	if email.recipient != "alice@example.com" || email.templateFile != "user_welcome.tmpl" {
+		t.Errorf("want welcome email to alice@example.com got %+v", email)
+	}
+}
+
+func TestRegisterUserHandler_ReturnValidationErrorOnDuplicateEmail(t *testing.T) {
+	t.Parallel()
+
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO users").
+			WillReturnError(&pq.Error{Code: "23505", Constraint: "users_email_key"})
+		mock.ExpectRollback()
+	})
+
+	s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout)}
+
+	input := `{"name":"alice","email":"alice@example.com","password":"pa55word1234"}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(input))
+	w := httptest.NewRecorder()
+	s.RegisterUserHandler(w, r)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("want status %d got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	want, err := helper.AnyToJSON(helper.Envelope{"error": map[string]string{"email": "a user with this email address already exists"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := w.Body.String(); want != got {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestRegisterUserHandler_ValidateBeforeHashingPassword(t *testing.T) {
+	t.Parallel()
+
+	// No database: the request must be rejected before any query.
+	s := &Server{}
+
+	input := `{"name":"","email":"alice@example.com","password":"` + strings.Repeat("a", 73) + `"}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(input))
+	w := httptest.NewRecorder()
+	s.RegisterUserHandler(w, r)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("want status %d got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	want, err := helper.AnyToJSON(helper.Envelope{"error": map[string]string{
+		"name":     "must be provided",
+		"password": "must not be more than 72 bytes long",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := w.Body.String(); want != got {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestActivateUserHandler_ActivateUserAndDeleteTokens(t *testing.T) {
+	t.Parallel()
+
+	token := "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		rows := sqlmock.NewRows([]string{"id", "created_at", "name", "email", "password_hash", "activated", "version"}).
+			AddRow(5, time.Now(), "alice", "alice@example.com", []byte("hash"), false, 1)
+		mock.ExpectQuery("INNER JOIN tokens").
+			WithArgs(sqlmock.AnyArg(), data.ScopeActivation, sqlmock.AnyArg()).
+			WillReturnRows(rows)
+		mock.ExpectBegin()
+		mock.ExpectQuery("UPDATE users").
+			WithArgs("alice", "alice@example.com", []byte("hash"), true, 5, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+		mock.ExpectExec("DELETE FROM tokens").
+			WithArgs(data.ScopeActivation, 5).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	})
+
+	s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout)}
+
+	r := httptest.NewRequest(http.MethodPut, "/v1/users/activated", strings.NewReader(`{"token":"`+token+`"}`))
+	w := httptest.NewRecorder()
+	s.ActivateUserHandler(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("want status %d got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestActivateUserHandler_RetryWithVersionRead(t *testing.T) {
+	t.Parallel()
+
+	token := "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"
+	db, mock := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		rows := sqlmock.NewRows([]string{"id", "created_at", "name", "email", "password_hash", "activated", "version"}).
+			AddRow(5, time.Now(), "alice", "alice@example.com", []byte("hash"), false, 1)
+		mock.ExpectQuery("INNER JOIN tokens").
+			WithArgs(sqlmock.AnyArg(), data.ScopeActivation, sqlmock.AnyArg()).
+			WillReturnRows(rows)
+
+		// The update succeeds but the transaction fails to serialize, so the
+		// retry must update version 1 again.
+		for _, deleteErr := range []error{&pq.Error{Code: "40001"}, nil} {
+			mock.ExpectBegin()
+			mock.ExpectQuery("UPDATE users").
+				WithArgs("alice", "alice@example.com", []byte("hash"), true, 5, 1).
+				WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+
+			if deleteErr != nil {
+				mock.ExpectExec("DELETE FROM tokens").WillReturnError(deleteErr)
+				mock.ExpectRollback()
+				continue
+			}
+
+			mock.ExpectExec("DELETE FROM tokens").
+				WithArgs(data.ScopeActivation, 5).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}
+	})
+
+	s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout)}
+
+	r := httptest.NewRequest(http.MethodPut, "/v1/users/activated", strings.NewReader(`{"token":"`+token+`"}`))
+	w := httptest.NewRecorder()
+	s.ActivateUserHandler(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("want status %d got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestActivateUserHandler_RejectUnknownToken(t *testing.T) {
+	t.Parallel()
+
+	db, _ := helper.NewSQLMock(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("INNER JOIN tokens").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	})
+
+	s := &Server{db: database.NewModels(db, database.DefaultQueryTimeout)}
+
+	r := httptest.NewRequest(http.MethodPut, "/v1/users/activated", strings.NewReader(`{"token":"Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"}`))
+	w := httptest.NewRecorder()
+	s.ActivateUserHandler(w, r)
+
+	want, err := helper.AnyToJSON(helper.Envelope{"error": map[string]string{"token": "invalid or expired activation token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := w.Body.String(); want != got {
+		t.Error(cmp.Diff(want, got))
+	}
+}
@@ -0,0 +1,62 @@
+package validator
+
+import (
+	"regexp"
+	"slices"
+)
+
+// EmailRX is the regular expression recommended by the W3C for sanity
+// checking email addresses.
+var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// Validator collects validation errors keyed by the name of the field that
+// failed.
+type Validator struct {
+	Errors map[string]string
+}
+
+// New returns a Validator with an empty errors map.
+func New() *Validator {
+	return &Validator{Errors: make(map[string]string)}
+}
+
+// Valid returns true if no errors were added.
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// AddError adds message for key, unless key already has an error so the first
+// failing check of a field wins.
+func (v *Validator) AddError(key, message string) {
+	if _, exists := v.Errors[key]; !exists {
+		v.Errors[key] = message
+	}
+}
+
+// Check adds message for key only if ok is false.
+func (v *Validator) Check(ok bool, key, message string) {
+	if !ok {
+		v.AddError(key, message)
+	}
+}
+
+// PermittedValue returns true if value is one of permittedValues.
+func PermittedValue[T comparable](value T, permittedValues ...T) bool {
+	return slices.Contains(permittedValues, value)
+}
+
+// Matches returns true if value matches rx.
+func Matches(value string, rx *regexp.Regexp) bool {
+	return rx.MatchString(value)
+}
+
+// Unique returns true if every element of values is distinct.
+func Unique[T comparable](values []T) bool {
+	uniqueValues := make(map[T]bool, len(values))
+
+	for _, value := range values {
+		uniqueValues[value] = true
+	}
+
+	return len(values) == len(uniqueValues)
+}
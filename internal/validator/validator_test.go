@@ -0,0 +1,71 @@
+package validator_test
+
+import (
+	"pilem/internal/validator"
+	"testing"
+)
+
+func TestValidatorCheck_KeepFirstErrorPerField(t *testing.T) {
+	t.Parallel()
+
+	v := validator.New()
+	if !v.Valid() {
+		t.Fatal("new validator must be valid")
+	}
+
+	v.Check(true, "title", "must be provided")
+	v.Check(false, "year", "must be provided")
+	v.Check(false, "year", "must not be in the future")
+
+	if v.Valid() {
+		t.Fatal("validator with errors must not be valid")
+	}
+
+	if len(v.Errors) != 1 {
+		t.Fatalf("want 1 error got %d: %v", len(v.Errors), v.Errors)
+	}
+
+	if got := v.Errors["year"]; got != "must be provided" {
+		t.Errorf("want first message for year got %q", got)
+	}
+}
+
+func TestPermittedValue(t *testing.T) {
+	t.Parallel()
+
+	if !validator.PermittedValue("id", "id", "-id") {
+		t.Error("want id to be permitted")
+	}
+
+	if validator.PermittedValue("created_at", "id", "-id") {
+		t.Error("want created_at not to be permitted")
+	}
+}
+
+func TestUnique(t *testing.T) {
+	t.Parallel()
+
+	if !validator.Unique([]string{"Action", "Drama"}) {
+		t.Error("want distinct values to be unique")
+	}
+
+	if validator.Unique([]string{"Action", "Drama", "Action"}) {
+		t.Error("want repeated values not to be unique")
+	}
+}
+
+func TestMatches_Email(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]bool{
+		"alice@example.com": true,
+		"alice@":            false,
+		"example.com":       false,
+	}
+
+	for email, want := range tests {
+		if got := validator.Matches(email, validator.EmailRX); got != want {
+			t.Errorf("Matches(%q) want %t got %t", email, want, got)
+		}
+	}
+}
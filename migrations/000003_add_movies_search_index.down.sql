@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS movies_search_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS search;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (to_tsvector('simple', title)) STORED;
+
+CREATE INDEX IF NOT EXISTS movies_search_idx ON movies USING GIN (search);
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS users_permissions;
+
+DROP TABLE IF EXISTS permissions;
\ No newline at end of file
@@ -0,0 +1,7 @@
+-- Rate limit counters are disposable, so skip the WAL for faster writes.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits (
+    key text NOT NULL,
+    window_start timestamp with time zone NOT NULL,
+    count integer NOT NULL,
+    PRIMARY KEY (key, window_start)
+);
\ No newline at end of file
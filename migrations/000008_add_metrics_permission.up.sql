@@ -0,0 +1,4 @@
+-- Grants access to /debug/vars and /metrics, which expose the runtime internals.
+INSERT INTO permissions (code)
+VALUES ('metrics:read')
+ON CONFLICT (code) DO NOTHING;
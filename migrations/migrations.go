@@ -0,0 +1,30 @@
+// Package migrations embeds the SQL migrations of the database schema, named
+// <version>_<name>.up.sql and <version>_<name>.down.sql.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// FS holds the PostgreSQL migrations.
+//
+//go:embed *.sql
+var FS embed.FS
+
+//go:embed sqlite/*.sql
+var sqlite embed.FS
+
+// SQLiteFS holds the SQLite migrations, which create the same tables as FS
+// but for the rate limits.
+var SQLiteFS, _ = fs.Sub(sqlite, "sqlite")
+
+// For returns the migrations of the database driver, "postgres" or
+// "sqlite".
+func For(driver string) fs.FS {
+	if driver == "sqlite" {
+		return SQLiteFS
+	}
+
+	return FS
+}
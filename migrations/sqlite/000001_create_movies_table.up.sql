@@ -0,0 +1,30 @@
+-- The movies table of the PostgreSQL migrations, with the genres stored as a
+-- JSON array and created_at as a Unix time.
+CREATE TABLE IF NOT EXISTS movies (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    title TEXT NOT NULL,
+    year INTEGER NOT NULL,
+    runtime INTEGER NOT NULL,
+    genres TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT movies_runtime_check CHECK (runtime >= 0),
+    CONSTRAINT movies_year_check CHECK (year >= 1888),
+    CONSTRAINT genres_length_check CHECK (json_array_length(genres) BETWEEN 1 AND 5)
+);
+
+-- A CHECK constraint can't use the current date, so the triggers check the
+-- upper bound of the year with the same error message.
+CREATE TRIGGER IF NOT EXISTS movies_year_check_insert
+BEFORE INSERT ON movies
+WHEN NEW.year > CAST(strftime('%Y', 'now') AS INTEGER)
+BEGIN
+    SELECT RAISE(ABORT, 'CHECK constraint failed: movies_year_check');
+END;
+
+CREATE TRIGGER IF NOT EXISTS movies_year_check_update
+BEFORE UPDATE OF year ON movies
+WHEN NEW.year > CAST(strftime('%Y', 'now') AS INTEGER)
+BEGIN
+    SELECT RAISE(ABORT, 'CHECK constraint failed: movies_year_check');
+END;
@@ -0,0 +1,11 @@
+-- The users table of the PostgreSQL migrations. NOCASE stands in for citext,
+-- so the emails only compare case-insensitively for ASCII letters.
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+    name TEXT NOT NULL,
+    email TEXT COLLATE NOCASE UNIQUE NOT NULL,
+    password_hash BLOB NOT NULL,
+    activated INTEGER NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1
+);
@@ -0,0 +1,8 @@
+-- The tokens table of the PostgreSQL migrations, with the expiry stored as a
+-- Unix time.
+CREATE TABLE IF NOT EXISTS tokens (
+    hash BLOB PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users ON DELETE CASCADE,
+    expiry INTEGER NOT NULL,
+    scope TEXT NOT NULL
+);
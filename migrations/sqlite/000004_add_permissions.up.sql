@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS users_permissions (
+    user_id INTEGER NOT NULL REFERENCES users ON DELETE CASCADE,
+    permission_id INTEGER NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (user_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('movies:read'),
+    ('movies:write'),
+    ('metrics:read')
+ON CONFLICT (code) DO NOTHING;
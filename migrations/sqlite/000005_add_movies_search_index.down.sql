@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS movies_search_update;
+
+DROP TRIGGER IF EXISTS movies_search_delete;
+
+DROP TRIGGER IF EXISTS movies_search_insert;
+
+DROP TABLE IF EXISTS movies_search;
@@ -0,0 +1,30 @@
+-- The full-text index of the movie titles, kept in sync with the movies table
+-- by the triggers. The unicode61 tokenizer lowercases the words but keeps the
+-- diacritics, like the simple text search configuration.
+CREATE VIRTUAL TABLE IF NOT EXISTS movies_search USING fts5(
+    title,
+    content = 'movies',
+    content_rowid = 'id',
+    tokenize = 'unicode61 remove_diacritics 0'
+);
+
+INSERT INTO movies_search (movies_search) VALUES ('rebuild');
+
+CREATE TRIGGER IF NOT EXISTS movies_search_insert
+AFTER INSERT ON movies
+BEGIN
+    INSERT INTO movies_search (rowid, title) VALUES (NEW.id, NEW.title);
+END;
+
+CREATE TRIGGER IF NOT EXISTS movies_search_delete
+AFTER DELETE ON movies
+BEGIN
+    INSERT INTO movies_search (movies_search, rowid, title) VALUES ('delete', OLD.id, OLD.title);
+END;
+
+CREATE TRIGGER IF NOT EXISTS movies_search_update
+AFTER UPDATE OF title ON movies
+BEGIN
+    INSERT INTO movies_search (movies_search, rowid, title) VALUES ('delete', OLD.id, OLD.title);
+    INSERT INTO movies_search (rowid, title) VALUES (NEW.id, NEW.title);
+END;